@@ -0,0 +1,83 @@
+package xm
+
+// auxBus is an auxiliary mixing bus.
+// It receives the signal portions sent by the channels
+// and runs them through a stereo echo or a reverb (see AuxEffect).
+//
+// The echo uses two feedback delay lines of different lengths,
+// so the repeats are spread across the stereo field.
+type auxBus struct {
+	effect AuxEffect
+
+	left  delayLine
+	right delayLine
+
+	feedback float64
+	wet      float64
+
+	// The reverb is allocated on demand, it needs a lot of memory.
+	reverb     *reverb
+	sampleRate float64
+}
+
+type delayLine struct {
+	buf []float64
+	pos int
+}
+
+func newAuxBus(sampleRate float64) *auxBus {
+	return &auxBus{
+		left:       delayLine{buf: make([]float64, int(sampleRate*0.150))},
+		right:      delayLine{buf: make([]float64, int(sampleRate*0.205))},
+		feedback:   0.45,
+		wet:        0.6,
+		sampleRate: sampleRate,
+	}
+}
+
+// SetEffect selects the bus effect.
+// The reverb room parameters are only used by AuxReverb.
+func (a *auxBus) SetEffect(effect AuxEffect, roomSize, damping float64) {
+	a.effect = effect
+	if effect == AuxReverb {
+		if a.reverb == nil {
+			a.reverb = newReverb(a.sampleRate)
+		}
+		a.reverb.SetRoom(roomSize, damping)
+	}
+}
+
+func (a *auxBus) Reset() {
+	a.left.Reset()
+	a.right.Reset()
+	if a.reverb != nil {
+		a.reverb.Reset()
+	}
+}
+
+// Process pushes the next input frame to the bus and returns the processed output.
+func (a *auxBus) Process(v float64) (left, right float64) {
+	if a.effect == AuxReverb {
+		return a.reverb.Process(v)
+	}
+	left = a.left.Process(v, a.feedback)
+	right = a.right.Process(v, a.feedback)
+	return left * a.wet, right * a.wet
+}
+
+func (d *delayLine) Reset() {
+	for i := range d.buf {
+		d.buf[i] = 0
+	}
+	d.pos = 0
+}
+
+func (d *delayLine) Process(v, feedback float64) float64 {
+	out := d.buf[d.pos]
+	d.buf[d.pos] = v + out*feedback
+	d.pos++
+	if d.pos == len(d.buf) {
+		d.pos = 0
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package xm
+
+import (
+	"math"
+)
+
+// channelFilterParams is a channel filter configuration (see Stream.SetChannelFilter).
+type channelFilterParams struct {
+	kind      FilterKind
+	cutoff    float64
+	resonance float64
+}
+
+// biquadFilter is a second-order IIR filter with the RBJ cookbook coefficients.
+// It processes the channel mono signal before the panning.
+type biquadFilter struct {
+	// params the coefficients were computed for.
+	params channelFilterParams
+
+	b0, b1, b2 float64
+	a1, a2     float64
+
+	x1, x2 float64
+	y1, y2 float64
+}
+
+// filterSilence is the smallest filter output value that is not flushed to zero.
+// It's much lower than the 16-bit resolution; the flushing prevents
+// the decaying filter state from reaching the slow denormal numbers.
+const filterSilence = 1e-15
+
+// Configure updates the filter coefficients if the params have changed.
+// The filter state is preserved, so the cutoff sweeps are continuous.
+func (f *biquadFilter) Configure(params channelFilterParams, sampleRate float64) {
+	if f.params == params {
+		return
+	}
+	f.params = params
+	if params.kind == FilterNone {
+		f.x1, f.x2, f.y1, f.y2 = 0, 0, 0, 0
+		return
+	}
+
+	// The resonance maps to the filter Q: 0 gives a flat Butterworth response,
+	// 1 is a sharp peak (Q=16/sqrt(2)).
+	q := math.Sqrt2 / 2 * math.Exp2(4*params.resonance)
+	cutoff := clamp(params.cutoff, 10, 0.45*sampleRate)
+	w0 := 2 * math.Pi * cutoff / sampleRate
+	cosw0 := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * q)
+
+	var b0, b1, b2 float64
+	switch params.kind {
+	case FilterHighPass:
+		b0 = (1 + cosw0) / 2
+		b1 = -(1 + cosw0)
+		b2 = (1 + cosw0) / 2
+	default:
+		b0 = (1 - cosw0) / 2
+		b1 = 1 - cosw0
+		b2 = (1 - cosw0) / 2
+	}
+	a0 := 1 + alpha
+	f.b0 = b0 / a0
+	f.b1 = b1 / a0
+	f.b2 = b2 / a0
+	f.a1 = -2 * cosw0 / a0
+	f.a2 = (1 - alpha) / a0
+}
+
+// IsActive reports whether the filter changes the signal.
+func (f *biquadFilter) IsActive() bool {
+	return f.params.kind != FilterNone
+}
+
+// Next filters the next sample.
+func (f *biquadFilter) Next(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	if abs(y) < filterSilence {
+		y = 0
+	}
+	f.x2 = f.x1
+	f.x1 = x
+	f.y2 = f.y1
+	f.y1 = y
+	return y
+}
+
+// Process filters the samples in place.
+// It's equivalent to calling Next for every sample.
+func (f *biquadFilter) Process(samples []float64) {
+	x1, x2, y1, y2 := f.x1, f.x2, f.y1, f.y2
+	for i, x := range samples {
+		y := f.b0*x + f.b1*x1 + f.b2*x2 - f.a1*y1 - f.a2*y2
+		if abs(y) < filterSilence {
+			y = 0
+		}
+		x2 = x1
+		x1 = x
+		y2 = y1
+		y1 = y
+		samples[i] = y
+	}
+	f.x1, f.x2, f.y1, f.y2 = x1, x2, y1, y2
+}
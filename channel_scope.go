@@ -0,0 +1,65 @@
+package xm
+
+// channelScope keeps the recent output of every channel for the scope displays.
+//
+// All channels share the same write position, so their buffers
+// are always in sync (a frame with index i is the same moment
+// of time for all channels).
+type channelScope struct {
+	// frames is a numChannels*size matrix of the channel output frames.
+	frames []float32
+	size   int
+	pos    int
+}
+
+func newChannelScope(numChannels, size int) *channelScope {
+	return &channelScope{
+		frames: make([]float32, numChannels*size),
+		size:   size,
+	}
+}
+
+func (sc *channelScope) Reset() {
+	for i := range sc.frames {
+		sc.frames[i] = 0
+	}
+	sc.pos = 0
+}
+
+// BeginTick clears the frames that are going to be written during the tick.
+// The channels that are not playing during the tick would keep
+// the zero values (silence).
+func (sc *channelScope) BeginTick(numFrames int) {
+	numFrames = clampMax(numFrames, sc.size)
+	for offset := 0; offset < len(sc.frames); offset += sc.size {
+		buf := sc.frames[offset : offset+sc.size]
+		pos := sc.pos
+		for i := 0; i < numFrames; i++ {
+			buf[pos] = 0
+			pos++
+			if pos == sc.size {
+				pos = 0
+			}
+		}
+	}
+}
+
+// Put writes the channel output for the frame at the current position.
+func (sc *channelScope) Put(channel int, v float64) {
+	sc.frames[channel*sc.size+sc.pos] = float32(v * (1.0 / 32768.0))
+}
+
+// NextFrame advances the write position.
+func (sc *channelScope) NextFrame() {
+	sc.pos++
+	if sc.pos == sc.size {
+		sc.pos = 0
+	}
+}
+
+// AppendChannel appends the channel frames to dst, starting from the oldest one.
+func (sc *channelScope) AppendChannel(dst []float32, channel int) []float32 {
+	buf := sc.frames[channel*sc.size : (channel+1)*sc.size]
+	dst = append(dst, buf[sc.pos:]...)
+	return append(dst, buf[:sc.pos]...)
+}
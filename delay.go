@@ -0,0 +1,88 @@
+package xm
+
+// masterDelay is a tempo-synced stereo delay of the mixed output.
+// See Stream.SetDelay.
+//
+// The delay length is measured in ticks, so it's re-calculated
+// when the song tempo or BPM changes (see Sync).
+// The buffer is reserved for the slowest tempo and BPM (see Reserve).
+type masterDelay struct {
+	config DelayConfig
+
+	// buf is a ring buffer of the interleaved stereo frames.
+	buf    []float64
+	pos    int
+	length int
+}
+
+func newMasterDelay(config DelayConfig) *masterDelay {
+	return &masterDelay{config: config}
+}
+
+func (d *masterDelay) Reset() {
+	for i := range d.buf {
+		d.buf[i] = 0
+	}
+	d.pos = 0
+}
+
+// Reserve makes the buffer large enough for a delay of numFrames frames.
+//
+// It's called outside of the mixing (see Stream.reserveTickBuffers),
+// so Sync never needs to allocate during the playback.
+// The delayed frames are preserved, so the echo tail continues smoothly.
+func (d *masterDelay) Reserve(numFrames int) {
+	capacity := len(d.buf) / 2
+	if numFrames <= capacity {
+		return
+	}
+	// Re-arrange the frames from the oldest to the newest,
+	// so the newest frame is right before the ring position 0.
+	buf := make([]float64, 2*numFrames)
+	offset := len(buf) - len(d.buf)
+	n := copy(buf[offset:], d.buf[2*d.pos:])
+	copy(buf[offset+n:], d.buf[:2*d.pos])
+	d.buf = buf
+	d.pos = 0
+}
+
+// Sync updates the delay length for the current tick duration.
+//
+// The length is limited by the reserved buffer capacity (see Reserve).
+func (d *masterDelay) Sync(ticksPerRow int, samplesPerTick float64) {
+	numTicks := d.config.Rows*ticksPerRow + d.config.Ticks
+	d.length = clamp(int(float64(numTicks)*samplesPerTick), 1, len(d.buf)/2)
+}
+
+// Process adds the delayed signal to the stereo mix frames
+// and feeds the mix into the delay line.
+func (d *masterDelay) Process(mix []float64) {
+	buf := d.buf
+	capacity := len(buf) / 2
+	feedback := d.config.Feedback
+	level := d.config.Mix
+	for i := 0; i < len(mix); i += 2 {
+		j := d.pos - d.length
+		if j < 0 {
+			j += capacity
+		}
+		delayedLeft := buf[2*j]
+		delayedRight := buf[2*j+1]
+		left := mix[i]
+		right := mix[i+1]
+		if d.config.PingPong {
+			// The input enters the left line, the repeats cross the sides.
+			buf[2*d.pos] = (left+right)*0.5 + delayedRight*feedback
+			buf[2*d.pos+1] = delayedLeft * feedback
+		} else {
+			buf[2*d.pos] = left + delayedLeft*feedback
+			buf[2*d.pos+1] = right + delayedRight*feedback
+		}
+		mix[i] = left + delayedLeft*level
+		mix[i+1] = right + delayedRight*level
+		d.pos++
+		if d.pos == capacity {
+			d.pos = 0
+		}
+	}
+}
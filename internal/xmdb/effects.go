@@ -28,19 +28,32 @@ const (
 	// Arg: portamento speed
 	EffectPortamentoDown
 
-	// Encoding: effect=0x03
+	// Encoding: effect=0x03 [or] volume byte
 	// Arg: portamento speed
 	// Note: also known as portamento-to-none and tone portamento
 	EffectNotePortamento
 
-	// Encoding: effect=0x04
+	// Encoding: effect=0x04 [or] volume byte
 	// Arg: speed & depth
 	EffectVibrato
 
+	// Encoding: part of the volume byte
+	// Arg: vibrato speed
+	EffectSetVibratoSpeed
+
+	// Encoding: effect=0x05
+	// Arg: same as in EffectVolumeSlide
+	// Note: the portamento speed is taken from the last EffectNotePortamento
+	EffectNotePortamentoWithVolumeSlide
+
 	// Encoding: effect=0x06
 	// Arg: same as in EffectVolumeSlide
 	EffectVibratoWithVolumeSlide
 
+	// Encoding: effect=0x07
+	// Arg: speed & depth
+	EffectTremolo
+
 	// Encoding: effect=0x0A
 	// Arg: slide up/down speed
 	EffectVolumeSlide
@@ -49,8 +62,13 @@ const (
 	// Arg: volume level
 	EffectSetVolume
 
+	// Encoding: effect=0x0B
+	// Arg: target pattern order index
+	EffectPositionJump
+
 	// Encoding: effect=0x0D
 	// Arg: target row number (on the next pattern)
+	// Note: the row number is decoded from BCD (D32 means row 32)
 	EffectPatternBreak
 
 	// Encoding: part of the volume byte
@@ -93,17 +111,76 @@ const (
 	// Arg: tick number
 	EffectNoteCut
 
+	// Encoding: effect=0x0E and x=D
+	// Arg: tick number
+	EffectNoteDelay
+
+	// Encoding: effect=0x0E and x=1
+	// Arg: portamento speed
+	EffectFinePortamentoUp
+
+	// Encoding: effect=0x0E and x=2
+	// Arg: portamento speed
+	EffectFinePortamentoDown
+
+	// Encoding: effect=0x0E and x=3
+	// Arg: 0 - smooth note portamento, 1 - semitone steps
+	EffectGlissandoControl
+
+	// Encoding: effect=0x0E and x=4
+	// Arg: waveform (2 low bits) and the "no retrigger" flag (bit 2)
+	EffectSetVibratoControl
+
+	// Encoding: effect=0x0E and x=5
+	// Arg: finetune (8 is a zero finetune)
+	EffectSetFinetune
+
+	// Encoding: effect=0x0E and x=6
+	// Arg: 0 - set the loop start, otherwise the number of repetitions
+	EffectPatternLoop
+
+	// Encoding: effect=0x0E and x=7
+	// Arg: waveform (2 low bits) and the "no retrigger" flag (bit 2)
+	EffectSetTremoloControl
+
+	// Encoding: effect=0x0E and x=9
+	// Arg: retrigger interval (in ticks)
+	EffectRetrigger
+
+	// Encoding: effect=0x0E and x=A (up) or x=B (down)
+	// Arg: slide speed
+	// Note: unlike the volume column fine slides, these remember the last speed
+	EffectExtFineVolumeSlideUp
+	EffectExtFineVolumeSlideDown
+
+	// Encoding: effect=0x15
+	// Arg: envelope position (in ticks)
+	EffectSetEnvelopePosition
+
 	// Encoding: effect=0x19
 	// Arg: slide left/right speed
 	EffectPanningSlide
 
-	// Encoding: effect=0x08 [or] volume byte
+	// Encoding: effect=0x1B
+	// Arg: volume change mode & retrigger interval (in ticks)
+	EffectMultiRetrigger
+
+	// Encoding: effect=0x21 and x=1 (up) or x=2 (down)
+	// Arg: portamento speed (4 times finer than EffectFinePortamentoUp)
+	EffectExtraFinePortamentoUp
+	EffectExtraFinePortamentoDown
+
+	// Encoding: effect=0x08 [or] effect=0x0E and x=8 [or] volume byte
 	// Arg: panning position
 	EffectSetPanning
 
 	// Encoding effect=0x09
 	// Arg: offset
 	EffectSampleOffset
+
+	// Encoding: effect=0x0E and x=E
+	// Arg: number of rows to delay
+	EffectPatternDelay
 )
 
 func ConvertEffect(n xmfile.PatternNote) Effect {
@@ -127,9 +204,15 @@ func ConvertEffect(n xmfile.PatternNote) Effect {
 	case 0x04:
 		e.Op = EffectVibrato
 
+	case 0x05:
+		e.Op = EffectNotePortamentoWithVolumeSlide
+
 	case 0x06:
 		e.Op = EffectVibratoWithVolumeSlide
 
+	case 0x07:
+		e.Op = EffectTremolo
+
 	case 0x08:
 		e.Op = EffectSetPanning
 
@@ -142,13 +225,46 @@ func ConvertEffect(n xmfile.PatternNote) Effect {
 	case 0x0C:
 		e.Op = EffectSetVolume
 
+	case 0x0B:
+		e.Op = EffectPositionJump
+
 	case 0x0D:
 		e.Op = EffectPatternBreak
+		// The parameter is a decimal-coded value.
+		e.Arg = (e.Arg>>4)*10 + (e.Arg & 0x0F)
 
 	case 0x0E:
 		switch e.Arg >> 4 {
+		case 0x01:
+			e.Op = EffectFinePortamentoUp
+		case 0x02:
+			e.Op = EffectFinePortamentoDown
+		case 0x03:
+			e.Op = EffectGlissandoControl
+		case 0x04:
+			e.Op = EffectSetVibratoControl
+		case 0x05:
+			e.Op = EffectSetFinetune
+		case 0x06:
+			e.Op = EffectPatternLoop
+		case 0x07:
+			e.Op = EffectSetTremoloControl
+		case 0x08:
+			// A coarse (16 steps) panning.
+			e.Op = EffectSetPanning
+			e.Arg = (e.Arg & 0x0F) << 4
+		case 0x09:
+			e.Op = EffectRetrigger
+		case 0x0A:
+			e.Op = EffectExtFineVolumeSlideUp
+		case 0x0B:
+			e.Op = EffectExtFineVolumeSlideDown
 		case 0x0C:
 			e.Op = EffectNoteCut
+		case 0x0D:
+			e.Op = EffectNoteDelay
+		case 0x0E:
+			e.Op = EffectPatternDelay
 		}
 
 	case 0x0F:
@@ -170,9 +286,23 @@ func ConvertEffect(n xmfile.PatternNote) Effect {
 	case 0x14:
 		e.Op = EffectKeyOff
 
+	case 0x15:
+		e.Op = EffectSetEnvelopePosition
+
 	case 0x19:
 		e.Op = EffectPanningSlide
 
+	case 0x1B:
+		e.Op = EffectMultiRetrigger
+
+	case 0x21:
+		switch e.Arg >> 4 {
+		case 0x01:
+			e.Op = EffectExtraFinePortamentoUp
+		case 0x02:
+			e.Op = EffectExtraFinePortamentoDown
+		}
+
 	default:
 		fmt.Printf("unsupported effect: %02X\n", n.EffectType)
 	}
@@ -191,6 +321,10 @@ func EffectFromVolumeByte(v uint8) Effect {
 		e.Op = EffectSetVolume
 		e.Arg = v - 0x10
 
+	case v >= 0x51 && v <= 0x5F:
+		// These values are out of the volume range.
+		// FastTracker II ignores them, so do we.
+
 	case v >= 0x60 && v <= 0x6F:
 		e.Op = EffectVolumeSlideDown
 		e.Arg = v & 0x0F
@@ -207,10 +341,19 @@ func EffectFromVolumeByte(v uint8) Effect {
 		e.Op = EffectFineVolumeSlideUp
 		e.Arg = v & 0x0F
 
+	case v >= 0xA0 && v <= 0xAF:
+		e.Op = EffectSetVibratoSpeed
+		e.Arg = v & 0x0F
+
+	case v >= 0xB0 && v <= 0xBF:
+		// A vibrato with zero speed uses the last speed value.
+		e.Op = EffectVibrato
+		e.Arg = v & 0x0F
+
 	case v >= 0xC0 && v <= 0xCF:
-		argBits := v & 0x0F
+		// Like in FastTracker II, CF gives 0xF0 (not 0xFF).
 		e.Op = EffectSetPanning
-		e.Arg = (argBits << 4) | argBits
+		e.Arg = (v & 0x0F) << 4
 
 	case v >= 0xD0 && v <= 0xDF:
 		e.Op = EffectPanningSlideLeft
@@ -220,6 +363,11 @@ func EffectFromVolumeByte(v uint8) Effect {
 		e.Op = EffectPanningSlideRight
 		e.Arg = v & 0x0F
 
+	case v >= 0xF0:
+		// Fx is equivalent to 3(x*16).
+		e.Op = EffectNotePortamento
+		e.Arg = (v & 0x0F) << 4
+
 	default:
 		fmt.Printf("unhandled volume column: %02X\n", v)
 	}
@@ -227,6 +375,62 @@ func EffectFromVolumeByte(v uint8) Effect {
 	return e
 }
 
+var effectOpNames = [...]string{
+	EffectNone:                          "None",
+	EffectArpeggio:                      "Arpeggio",
+	EffectPortamentoUp:                  "PortamentoUp",
+	EffectPortamentoDown:                "PortamentoDown",
+	EffectNotePortamento:                "NotePortamento",
+	EffectVibrato:                       "Vibrato",
+	EffectSetVibratoSpeed:               "SetVibratoSpeed",
+	EffectNotePortamentoWithVolumeSlide: "NotePortamentoWithVolumeSlide",
+	EffectVibratoWithVolumeSlide:        "VibratoWithVolumeSlide",
+	EffectTremolo:                       "Tremolo",
+	EffectVolumeSlide:                   "VolumeSlide",
+	EffectSetVolume:                     "SetVolume",
+	EffectPositionJump:                  "PositionJump",
+	EffectPatternBreak:                  "PatternBreak",
+	EffectVolumeSlideDown:               "VolumeSlideDown",
+	EffectVolumeSlideUp:                 "VolumeSlideUp",
+	EffectFineVolumeSlideDown:           "FineVolumeSlideDown",
+	EffectFineVolumeSlideUp:             "FineVolumeSlideUp",
+	EffectPanningSlideLeft:              "PanningSlideLeft",
+	EffectPanningSlideRight:             "PanningSlideRight",
+	EffectSetBPM:                        "SetBPM",
+	EffectSetTempo:                      "SetTempo",
+	EffectSetGlobalVolume:               "SetGlobalVolume",
+	EffectGlobalVolumeSlide:             "GlobalVolumeSlide",
+	EffectEarlyKeyOff:                   "EarlyKeyOff",
+	EffectKeyOff:                        "KeyOff",
+	EffectNoteCut:                       "NoteCut",
+	EffectNoteDelay:                     "NoteDelay",
+	EffectFinePortamentoUp:              "FinePortamentoUp",
+	EffectFinePortamentoDown:            "FinePortamentoDown",
+	EffectGlissandoControl:              "GlissandoControl",
+	EffectSetVibratoControl:             "SetVibratoControl",
+	EffectSetFinetune:                   "SetFinetune",
+	EffectPatternLoop:                   "PatternLoop",
+	EffectSetTremoloControl:             "SetTremoloControl",
+	EffectRetrigger:                     "Retrigger",
+	EffectExtFineVolumeSlideUp:          "ExtFineVolumeSlideUp",
+	EffectExtFineVolumeSlideDown:        "ExtFineVolumeSlideDown",
+	EffectSetEnvelopePosition:           "SetEnvelopePosition",
+	EffectPanningSlide:                  "PanningSlide",
+	EffectMultiRetrigger:                "MultiRetrigger",
+	EffectExtraFinePortamentoUp:         "ExtraFinePortamentoUp",
+	EffectExtraFinePortamentoDown:       "ExtraFinePortamentoDown",
+	EffectSetPanning:                    "SetPanning",
+	EffectSampleOffset:                  "SampleOffset",
+	EffectPatternDelay:                  "PatternDelay",
+}
+
+func (op EffectOp) String() string {
+	if op >= 0 && int(op) < len(effectOpNames) {
+		return effectOpNames[op]
+	}
+	return "Unknown"
+}
+
 func (e Effect) AsUint16() uint16 {
 	return (uint16(e.Op) << 8) | uint16(e.Arg)
 }
@@ -0,0 +1,127 @@
+package xm
+
+import (
+	"math"
+)
+
+// samplePosFracScale converts the fixed-point position fraction into a [0, 1) value.
+const samplePosFracScale = 1.0 / (1 << samplePosFracBits)
+
+const (
+	// sincTaps is the number of frames the sinc filter uses:
+	// the frames from i-3 to i+4 for the position inside the frame i.
+	sincTaps = 8
+
+	// sincPhaseBits is the resolution of the sinc table:
+	// the position fraction is rounded down to one of 2^sincPhaseBits phases.
+	sincPhaseBits = 10
+)
+
+// sincTable holds the precomputed windowed sinc filter coefficients.
+// The row index is the position fraction phase.
+var sincTable = makeSincTable()
+
+func makeSincTable() *[1 << sincPhaseBits][sincTaps]float32 {
+	var table [1 << sincPhaseBits][sincTaps]float32
+	const halfWidth = sincTaps / 2
+	for phase := range table {
+		t := float64(phase) / (1 << sincPhaseBits)
+		sum := 0.0
+		var row [sincTaps]float64
+		for k := range row {
+			// The distance from the position to the frame i+k-3.
+			x := float64(k-(halfWidth-1)) - t
+			// The Blackman window spans the taps range.
+			w := (x + halfWidth) / sincTaps
+			window := 0.42 - 0.5*math.Cos(2*math.Pi*w) + 0.08*math.Cos(4*math.Pi*w)
+			row[k] = sinc(x) * window
+			sum += row[k]
+		}
+		// The normalization keeps the DC gain at 1 for every phase.
+		for k, v := range row {
+			table[phase][k] = float32(v / sum)
+		}
+	}
+	return &table
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// sampleAt returns the sample value at the fixed-point position.
+// The position frame should be inside the sample.
+//
+// The value is interpolated according to the instrument interpolation mode.
+// The float32 precision is more than enough for the 16-bit frames;
+// it also keeps the note cache frames compact (see noteCache).
+func (inst *instrument) sampleAt(pos uint64) float32 {
+	i := pos >> samplePosFracBits
+	if inst.interpolation == InterpolationNone {
+		return float32(inst.samples[i])
+	}
+	frac := pos & (1<<samplePosFracBits - 1)
+	t := float32(frac) * samplePosFracScale
+
+	switch inst.interpolation {
+	case InterpolationCubic:
+		var p [4]float32
+		inst.readFrames(p[:], int(i)-1)
+		return p[1] + 0.5*t*(p[2]-p[0]+t*(2*p[0]-5*p[1]+4*p[2]-p[3]+t*(3*(p[1]-p[2])+p[3]-p[0])))
+
+	case InterpolationSinc:
+		var p [sincTaps]float32
+		inst.readFrames(p[:], int(i)-(sincTaps/2-1))
+		k := &sincTable[frac>>(samplePosFracBits-sincPhaseBits)]
+		return p[0]*k[0] + p[1]*k[1] + p[2]*k[2] + p[3]*k[3] +
+			p[4]*k[4] + p[5]*k[5] + p[6]*k[6] + p[7]*k[7]
+
+	default:
+		u := float32(inst.samples[i])
+		var v float32
+		if i+1 < inst.wrapFrame {
+			v = float32(inst.samples[i+1])
+		} else {
+			v = float32(inst.frameAt(int(i) + 1))
+		}
+		return u + t*(v-u)
+	}
+}
+
+// readFrames fills dst with the frames starting from the index i.
+func (inst *instrument) readFrames(dst []float32, i int) {
+	if i >= 0 && i+len(dst) <= int(inst.wrapFrame) {
+		for k, v := range inst.samples[i : i+len(dst)] {
+			dst[k] = float32(v)
+		}
+		return
+	}
+	for k := range dst {
+		dst[k] = float32(inst.frameAt(i + k))
+	}
+}
+
+// frameAt returns the sample frame as it's heard during the playback:
+// the frames past the loop end are taken from the loop start,
+// the frames outside of the sample are silent.
+// The frames before the loop start are the sample frames even
+// after the loop wraps; it's a negligible difference for the filters.
+//
+// It's a slow path for the interpolation near the sample boundaries,
+// the frames below inst.wrapFrame can be accessed directly.
+func (inst *instrument) frameAt(i int) int16 {
+	if i < 0 {
+		return 0
+	}
+	k := uint64(i)
+	if k >= inst.loopEndFrame {
+		k = inst.loopStartFrame + (k-inst.loopStartFrame)%inst.loopLengthFrame
+	}
+	if k >= uint64(len(inst.samples)) {
+		return 0
+	}
+	return inst.samples[k]
+}
@@ -0,0 +1,86 @@
+package xm
+
+import (
+	"math"
+	"testing"
+)
+
+// newInterpolationTestChannel returns a channel that plays the instrument
+// compiled with the given interpolation mode.
+func newInterpolationTestChannel(t *testing.T, inst testInstrument, mode InterpolationMode, step float64) *streamChannel {
+	t.Helper()
+	song := newTestSong(1, 1)
+	song.instruments = []testInstrument{inst}
+	s := newTestStream(t, song, LoadModuleConfig{Interpolation: mode})
+	ch := &streamChannel{inst: &s.module.instruments[0]}
+	ch.SetSampleStep(step)
+	return ch
+}
+
+var interpolationModes = []InterpolationMode{
+	InterpolationLinear,
+	InterpolationNone,
+	InterpolationCubic,
+	InterpolationSinc,
+}
+
+func TestLinearInterpolation(t *testing.T) {
+	inst := testInstrument{volume: 64}
+	for i := 0; i < 32; i++ {
+		inst.data = append(inst.data, int8(i*4))
+	}
+	ch := newInterpolationTestChannel(t, inst, InterpolationLinear, 0.25)
+	// The frames in between are on the straight line.
+	for i := 0; i < 31*4; i++ {
+		want := float32(i) * 0.25 * 4 * 256
+		if v := ch.NextSample(); v != want {
+			t.Fatalf("frame %d is %v, want %v", i, v, want)
+		}
+	}
+}
+
+func TestInterpolationModes(t *testing.T) {
+	// The filters take the frames before the sample start as silent,
+	// so the DC loop starts after a few frames.
+	dc := testInstrument{volume: 64, loopType: 1, loopStart: 8, loopLength: 16}
+	for i := 0; i < 24; i++ {
+		dc.data = append(dc.data, 40)
+	}
+	var noise testInstrument
+	rng := uint32(1)
+	for i := 0; i < 100; i++ {
+		noise.data = append(noise.data, int8(waveform(waveformRandom, 0, &rng)/2))
+	}
+
+	for _, mode := range interpolationModes {
+		// A constant signal stays constant.
+		ch := newInterpolationTestChannel(t, dc, mode, 0.37)
+		ch.SetSampleOffset(4)
+		for i := 0; i < 1000; i++ {
+			if v := ch.NextSample(); math.Abs(float64(v)-40*256) > 0.5 {
+				t.Fatalf("mode=%v: DC frame %d is %v, want %v", mode, i, v, 40*256)
+			}
+		}
+
+		// The integer positions are the sample frames.
+		ch = newInterpolationTestChannel(t, noise, mode, 1)
+		for i, want := range noise.data[:96] {
+			if v := ch.NextSample(); math.Abs(float64(v)-float64(int(want)*256)) > 0.5 {
+				t.Fatalf("mode=%v: frame %d is %v, want %v", mode, i, v, int(want)*256)
+			}
+		}
+
+		// ReadSamples is a faster NextSample loop, the results are identical.
+		for _, inst := range []testInstrument{dc, noise} {
+			ch1 := newInterpolationTestChannel(t, inst, mode, 0.37)
+			ch2 := newInterpolationTestChannel(t, inst, mode, 0.37)
+			block := make([]float64, 300)
+			ch2.ReadSamples(block)
+			for i, v := range block {
+				if want := float64(ch1.NextSample()); v != want {
+					t.Fatalf("mode=%v: ReadSamples frame %d is %v, want %v", mode, i, v, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package xm
+
+import (
+	"math"
+)
+
+const (
+	// The time it takes for the meter levels to decay
+	// by a factor of e after the signal goes silent.
+	levelDecaySeconds = 0.3
+)
+
+// Level is a signal level measurement.
+// Both values are in [0, 1] range, where 1 is the 16-bit PCM full scale.
+type Level struct {
+	// Peak is the maximum absolute sample value.
+	Peak float64
+
+	// RMS is the root mean square of the sample values.
+	RMS float64
+}
+
+// Levels holds the VU meter levels of the stream.
+// See Stream.GetLevels.
+type Levels struct {
+	// Left and Right are the master output levels.
+	Left  Level
+	Right Level
+
+	// Channels are the per-channel levels, indexed by the channel ID.
+	// A channel level is measured before the stereo panning is applied
+	// (the louder side is measured).
+	Channels []Level
+}
+
+// levelMeter tracks the output levels for the VU meters.
+//
+// The levels are collected for every tick and then combined
+// with the previous values using the exponential decay.
+type levelMeter struct {
+	left     levelState
+	right    levelState
+	channels []levelState
+}
+
+type levelState struct {
+	peak       float64
+	meanSquare float64
+
+	// The current tick accumulators.
+	tickPeak float64
+	tickSum  float64
+}
+
+func newLevelMeter(numChannels int) *levelMeter {
+	return &levelMeter{
+		channels: make([]levelState, numChannels),
+	}
+}
+
+func (m *levelMeter) Reset() {
+	m.left = levelState{}
+	m.right = levelState{}
+	for i := range m.channels {
+		m.channels[i] = levelState{}
+	}
+}
+
+// MeasureOutput updates the master levels using the mixed tick frames.
+func (m *levelMeter) MeasureOutput(mix []float64, tickSeconds float64) {
+	for i := 0; i < len(mix); i += 2 {
+		m.left.Add(mix[i])
+		m.right.Add(mix[i+1])
+	}
+	decay := math.Exp(-tickSeconds / levelDecaySeconds)
+	numFrames := len(mix) / 2
+	m.left.FinishTick(numFrames, decay)
+	m.right.FinishTick(numFrames, decay)
+}
+
+// FinishChannels updates the channel levels after the tick channel samples
+// were accumulated (see Stream.mixInstrumented).
+func (m *levelMeter) FinishChannels(numFrames int, tickSeconds float64) {
+	decay := math.Exp(-tickSeconds / levelDecaySeconds)
+	for i := range m.channels {
+		m.channels[i].FinishTick(numFrames, decay)
+	}
+}
+
+func (m *levelMeter) Levels() Levels {
+	levels := Levels{
+		Left:     m.left.Level(),
+		Right:    m.right.Level(),
+		Channels: make([]Level, len(m.channels)),
+	}
+	for i := range m.channels {
+		levels.Channels[i] = m.channels[i].Level()
+	}
+	return levels
+}
+
+func (st *levelState) Add(v float64) {
+	v = abs(v)
+	if v > st.tickPeak {
+		st.tickPeak = v
+	}
+	st.tickSum += v * v
+}
+
+func (st *levelState) FinishTick(numFrames int, decay float64) {
+	st.peak = math.Max(st.tickPeak, st.peak*decay)
+	if numFrames != 0 {
+		st.meanSquare = lerp(st.tickSum/float64(numFrames), st.meanSquare, decay)
+	}
+	st.tickPeak = 0
+	st.tickSum = 0
+}
+
+func (st *levelState) Level() Level {
+	const fullScale = 32768.0
+	peak := clampMax(st.peak/fullScale, 1)
+	// The peak and RMS decay at different rates,
+	// but RMS should never be reported above the peak.
+	rms := clampMax(math.Sqrt(st.meanSquare)/fullScale, peak)
+	return Level{Peak: peak, RMS: rms}
+}
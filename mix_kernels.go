@@ -0,0 +1,262 @@
+package xm
+
+import (
+	"runtime"
+	"sync"
+)
+
+// mixBlockFrames is the number of frames the mixer processes at once.
+//
+// The channels are mixed one by one: the channel samples of a block are
+// resampled into a scratch buffer and then accumulated into the output.
+// The block is small enough to keep the scratch buffer in the L1 cache.
+const mixBlockFrames = 256
+
+// minChannelsPerWorker is the smallest channel group that is worth
+// mixing on a separate goroutine (see mixWorkers).
+const minChannelsPerWorker = 8
+
+// mixChannels is the mixTick fast path: it mixes the active channels into mix.
+//
+// The summation order is the same as in the frame-by-frame mixing,
+// so the results are identical, but the channel-major loops are
+// much friendlier to the CPU (see accumulateStereo).
+// The parallel mode keeps that order too (see mixWorkers.Mix).
+func (s *Stream) mixChannels(mix []float64) {
+	numFrames := len(mix) / 2
+	for i := range mix {
+		mix[i] = 0
+	}
+	var aux []float64
+	if s.aux != nil {
+		s.auxMix = reuseSlice(s.auxMix, numFrames)
+		aux = s.auxMix
+	}
+
+	numGroups := 1
+	if s.workers != nil {
+		numGroups = clamp(len(s.activeChannels)/minChannelsPerWorker, 1, len(s.workers.tasks)+1)
+	}
+	if numGroups == 1 {
+		mixChannelGroup(s.activeChannels, mix, aux, &s.mixSamples, s.module.volumeRampStep)
+	} else {
+		s.workers.Mix(s.activeChannels, numGroups, mix, aux, s.module.volumeRampStep)
+	}
+
+	if aux != nil {
+		for i, v := range aux {
+			wetLeft, wetRight := s.aux.Process(v)
+			mix[2*i] += wetLeft
+			mix[2*i+1] += wetRight
+		}
+	}
+}
+
+// mixChannelGroup adds the channels output to the zeroed mix (stereo)
+// and aux (mono) buffers. The aux can be nil if there is no aux bus.
+// The channel volumes that are not settled yet are ramped by volumeRamp per frame.
+func mixChannelGroup(channels []*streamChannel, mix, aux []float64, scratch *[mixBlockFrames]float64, volumeRamp float64) {
+	for offset := 0; offset < len(mix)/2; offset += mixBlockFrames {
+		end := offset + mixBlockFrames
+		if end > len(mix)/2 {
+			end = len(mix) / 2
+		}
+		dst := mix[2*offset : 2*end]
+		var dstAux []float64
+		if aux != nil {
+			dstAux = aux[offset:end]
+		}
+		samples := scratch[:end-offset]
+		for _, ch := range channels {
+			readChannelSamples(ch, samples)
+			accumulateChannel(ch, dst, dstAux, samples, volumeRamp)
+		}
+	}
+}
+
+// readChannelSamples fills dst with the next channel samples (with the filter applied).
+// Only the channel playback state is changed, the output volumes are not used.
+func readChannelSamples(ch *streamChannel, dst []float64) {
+	ch.ReadSamples(dst)
+	if ch.filter.IsActive() {
+		ch.filter.Process(dst)
+	}
+}
+
+// accumulateChannel adds the channel samples scaled by its output volumes
+// to the dst (stereo) and aux (mono) buffers. The aux can be nil.
+func accumulateChannel(ch *streamChannel, dst, aux, samples []float64, volumeRamp float64) {
+	n := 0
+	if ch.IsRamping() {
+		n = accumulateRamp(ch, dst, aux, samples, volumeRamp)
+	}
+	accumulateStereo(dst[2*n:], samples[n:], ch.computedVolume[0], ch.computedVolume[1])
+	if aux != nil {
+		accumulateMono(aux[n:], samples[n:], ch.auxVolume)
+	}
+}
+
+// accumulateRamp is a frame-by-frame accumulation loop for the channel
+// which volume is being ramped (see streamChannel.slideVolumes).
+// It stops as soon as the target volumes are reached;
+// the number of the consumed src samples is returned.
+func accumulateRamp(ch *streamChannel, dst, aux, src []float64, volumeRamp float64) int {
+	i := 0
+	for ; i < len(src) && ch.IsRamping(); i++ {
+		v := src[i]
+		dst[2*i] += v * ch.computedVolume[0]
+		dst[2*i+1] += v * ch.computedVolume[1]
+		if aux != nil {
+			aux[i] += v * ch.auxVolume
+		}
+		ch.slideVolumes(volumeRamp)
+	}
+	return i
+}
+
+// accumulateStereo adds the src samples scaled by the left and right
+// volumes to the interleaved stereo frames of dst.
+//
+// The loop is unrolled: the independent operations of several frames
+// can be executed in parallel and the bound checks are performed once per 4 frames.
+func accumulateStereo(dst, src []float64, left, right float64) {
+	dst = dst[:2*len(src)]
+	i := 0
+	for ; i+4 <= len(src); i += 4 {
+		s := src[i : i+4 : i+4]
+		d := dst[2*i : 2*i+8 : 2*i+8]
+		d[0] += s[0] * left
+		d[1] += s[0] * right
+		d[2] += s[1] * left
+		d[3] += s[1] * right
+		d[4] += s[2] * left
+		d[5] += s[2] * right
+		d[6] += s[3] * left
+		d[7] += s[3] * right
+	}
+	for ; i < len(src); i++ {
+		dst[2*i] += src[i] * left
+		dst[2*i+1] += src[i] * right
+	}
+}
+
+// accumulateMono is like accumulateStereo, but for the mono dst.
+func accumulateMono(dst, src []float64, k float64) {
+	dst = dst[:len(src)]
+	i := 0
+	for ; i+4 <= len(src); i += 4 {
+		s := src[i : i+4 : i+4]
+		d := dst[i : i+4 : i+4]
+		d[0] += s[0] * k
+		d[1] += s[1] * k
+		d[2] += s[2] * k
+		d[3] += s[3] * k
+	}
+	for ; i < len(src); i++ {
+		dst[i] += src[i] * k
+	}
+}
+
+// mixWorkers mixes the channel groups on several goroutines
+// (see LoadModuleConfig.MaxWorkers).
+//
+// The workers only read the channel samples (the resampling and filtering
+// take most of the mixing time), the samples are accumulated by the calling
+// goroutine in the channels order. This way the output is identical
+// to the sequential mixing.
+//
+// The goroutines are started once and then reused for every tick,
+// so the parallel mixing doesn't allocate.
+// They don't reference the workers object itself: when it's
+// garbage collected, its finalizer stops the goroutines.
+type mixWorkers struct {
+	queue chan *mixTask
+	wg    *sync.WaitGroup
+
+	// The calling goroutine reads the first group,
+	// the tasks are used for the rest of them.
+	tasks []mixTask
+
+	// samples holds the current tick samples of every active channel.
+	samples [][]float64
+}
+
+type mixTask struct {
+	channels []*streamChannel
+	samples  [][]float64
+	wg       *sync.WaitGroup
+}
+
+func newMixWorkers(maxWorkers int) *mixWorkers {
+	w := &mixWorkers{
+		queue: make(chan *mixTask, maxWorkers-1),
+		wg:    &sync.WaitGroup{},
+		tasks: make([]mixTask, maxWorkers-1),
+	}
+	for i := range w.tasks {
+		w.tasks[i].wg = w.wg
+		go runMixWorker(w.queue)
+	}
+	runtime.SetFinalizer(w, (*mixWorkers).Stop)
+	return w
+}
+
+func runMixWorker(queue <-chan *mixTask) {
+	for t := range queue {
+		readChannelGroup(t.channels, t.samples)
+		t.channels = nil
+		t.samples = nil
+		t.wg.Done()
+	}
+}
+
+func readChannelGroup(channels []*streamChannel, samples [][]float64) {
+	for i, ch := range channels {
+		readChannelSamples(ch, samples[i])
+	}
+}
+
+// Stop terminates the worker goroutines.
+func (w *mixWorkers) Stop() {
+	runtime.SetFinalizer(w, nil)
+	close(w.queue)
+}
+
+// Reserve preallocates the sample buffers for the ticks of numFrames frames.
+func (w *mixWorkers) Reserve(numChannels, numFrames int) {
+	w.samples = growChannelSettings(w.samples, numChannels)
+	for i := range w.samples {
+		if cap(w.samples[i]) < numFrames {
+			w.samples[i] = make([]float64, 0, numFrames)
+		}
+	}
+}
+
+// Mix splits the channels into numGroups groups and reads their samples in parallel.
+// Then the samples are summed into the zeroed mix and aux buffers (see mixChannelGroup).
+func (w *mixWorkers) Mix(channels []*streamChannel, numGroups int, mix, aux []float64, volumeRamp float64) {
+	numFrames := len(mix) / 2
+	w.samples = growChannelSettings(w.samples, len(channels))
+	samples := w.samples[:len(channels)]
+	for i := range samples {
+		samples[i] = reuseSlice(samples[i], numFrames)
+	}
+
+	groupSize := (len(channels) + numGroups - 1) / numGroups
+	tasks := w.tasks[:numGroups-1]
+	w.wg.Add(len(tasks))
+	for i := range tasks {
+		t := &tasks[i]
+		from := clampMax((i+1)*groupSize, len(channels))
+		to := clampMax((i+2)*groupSize, len(channels))
+		t.channels = channels[from:to]
+		t.samples = samples[from:to]
+		w.queue <- t
+	}
+	readChannelGroup(channels[:groupSize], samples[:groupSize])
+	w.wg.Wait()
+
+	for i, ch := range channels {
+		accumulateChannel(ch, mix, aux, samples[i], volumeRamp)
+	}
+}
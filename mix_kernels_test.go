@@ -0,0 +1,71 @@
+package xm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParallelMixing(t *testing.T) {
+	const numChannels = 32
+	song := newTestSong(numChannels, 16)
+	song.instruments = append(song.instruments, sineInstrument(3))
+	for ch := 0; ch < numChannels; ch++ {
+		song.patterns[0][ch%4][ch] = testNote{
+			note:   uint8(25 + ch),
+			inst:   uint8(1 + ch%2),
+			vol:    uint8(0x10 + ch),
+			fx:     0x0A, // A volume slide keeps the volume ramping
+			param:  0x01,
+			filled: true,
+		}
+		song.patterns[0][8+ch%8][ch] = fx(0x08, uint8(ch*8)) // Panning changes
+	}
+
+	render := func(maxWorkers int, format SampleFormat) []byte {
+		config := LoadModuleConfig{MaxWorkers: maxWorkers, SampleFormat: format}
+		s := newTestStream(t, song, config)
+		for ch := 0; ch < numChannels; ch += 3 {
+			s.SetChannelAuxSend(ch, 0.5)
+			s.SetChannelFilter(ch, FilterLowPass, 2000, 0.5)
+		}
+		return readAll(t, s)
+	}
+
+	if peakLevel(render(0, SampleFormatInt16)) == 0 {
+		t.Fatal("the song is silent")
+	}
+	// The float output makes the smallest differences visible.
+	for _, format := range []SampleFormat{SampleFormatInt16, SampleFormatFloat32} {
+		sequential := render(0, format)
+		if parallel := render(4, format); !bytes.Equal(parallel, sequential) {
+			t.Fatalf("format=%v: the parallel mixing output doesn't match the sequential one", format)
+		}
+	}
+}
+
+func TestMixKernels(t *testing.T) {
+	// The level metering makes the stream use the frame-by-frame
+	// mixing loop (see mixInstrumented), the output should be the same.
+	song := newTestSong(6, 16)
+	song.instruments = append(song.instruments, sineInstrument(5))
+	for ch := 0; ch < 6; ch++ {
+		song.patterns[0][ch][ch] = testNote{note: byte(37 + ch*4), inst: byte(1 + ch%2), fx: 0x0A, param: 0x02, filled: true}
+		song.patterns[0][8+ch][ch] = testNote{note: byte(50 + ch), inst: 1, fx: 0x09, param: 0x01, filled: true}
+	}
+	// The ticks are 993 frames long at this BPM: that's not a multiple
+	// of the unrolled loops step or the mixing block size.
+	song.patterns[0][4][0] = fx(0x0F, 111)
+
+	render := func(instrumented bool) []byte {
+		s := newTestStream(t, song, LoadModuleConfig{SampleFormat: SampleFormatFloat32})
+		s.SetLevelMetering(instrumented)
+		s.SetChannelAuxSend(1, 0.7)
+		s.SetChannelFilter(2, FilterHighPass, 500, 0.3)
+		return readAll(t, s)
+	}
+	block := render(false)
+	frames := render(true)
+	if len(block) == 0 || !bytes.Equal(block, frames) {
+		t.Fatal("the block mixing output doesn't match the frame-by-frame mixing")
+	}
+}
@@ -8,30 +8,84 @@ import (
 type module struct {
 	instruments []instrument
 
+	// Names are only available if the module was parsed with strings.
+	instrumentNames []string
+	sampleNames     [][]string
+
 	patterns     []pattern
 	patternOrder []*pattern
 
 	effectTab []noteEffect
 	noteTab   []patternNote
 
+	// The memory pools for the instrument samples and the pattern notes.
+	// They're kept here to be reused by the next compilation (see release).
+	sampleData []int16
+	noteData   []uint16
+
 	sampleRate  float64
 	bpm         float64
 	ticksPerRow int
 
+	// The output PCM format.
+	// frameSize is the size of a stereo frame in that format.
+	format    SampleFormat
+	frameSize int
+	bigEndian bool
+
+	// The max channel volume change per output frame.
+	// It's adjusted to the sample rate, so the ramping takes
+	// the same time regardless of the output rate.
+	volumeRampStep float64
+
+	// Whether the playback continues from the first
+	// pattern after the end of the pattern order list.
+	wrapAround bool
+
+	// Whether the channels use the fixed LRRL panning (see LoadModuleConfig.AmigaPanning).
+	amigaPanning bool
+
+	// The pattern order index to continue from when the song is looped.
+	restartPosition int
+
 	// These values store the defaults for the stream.
 	samplesPerTick float64
-	bytesPerTick   int
+	framesPerTick  int
 	secondsPerRow  float64
 }
 
+// release returns an empty module that keeps the m memory buffers,
+// so they can be reused by the next compileModule call.
+// The m module should not be used after this call.
+func (m *module) release() module {
+	return module{
+		instruments:     m.instruments[:0],
+		instrumentNames: m.instrumentNames[:0],
+		sampleNames:     m.sampleNames[:0],
+		patterns:        m.patterns[:0],
+		patternOrder:    m.patternOrder[:0],
+		effectTab:       m.effectTab[:0],
+		noteTab:         m.noteTab[:0],
+		sampleData:      m.sampleData[:0],
+		noteData:        m.noteData[:0],
+	}
+}
+
 type moduleConfig struct {
-	sampleRate uint
-	bpm        uint
-	tempo      uint
-	subSamples bool
+	sampleRate    uint
+	bpm           uint
+	tempo         uint
+	subSamples    bool
+	interpolation InterpolationMode
+	noteRange     NoteRangeMode
+	wrapAround    bool
+	amigaPanning  bool
+	format        SampleFormat
+	bigEndian     bool
 }
 
 type pattern struct {
+	id          int
 	numChannels int
 	numRows     int
 	notes       []uint16
@@ -65,6 +119,8 @@ const (
 	noteHasNotePortamento = 1 << iota
 	noteHasArpeggio
 	noteHasVibrato
+	noteHasTremolo
+	noteHasNoteDelay
 	noteValid
 	noteBadInstrument
 	noteInitialized
@@ -101,12 +157,48 @@ type instrument struct {
 	loopStart  float64
 	loopEnd    float64
 
+	// The loop bounds as fixed-point sample positions (see updateLoopPositions).
+	loopStartPos  uint64
+	loopEndPos    uint64
+	loopLengthPos uint64
+
+	// The loop bounds in frames; they're used by the interpolation (see frameAt).
+	// The frames before wrapFrame are followed by the next frame in samples.
+	loopStartFrame  uint64
+	loopEndFrame    uint64
+	loopLengthFrame uint64
+	wrapFrame       uint64
+
+	interpolation InterpolationMode
+
 	numSubSamples int
 	id            int
 
+	// The sample frames info before any compiler transformations.
+	// It's used to re-calculate the loop if its type is changed.
+	numFrames      int
+	frameLoopStart int
+	frameLoopEnd   int
+
+	// noteHoles is a bitset of the notes that are not mapped
+	// to any of the compiled samples (see the keymap assignments).
+	noteHoles [2]uint64
+
+	// Whether the notes this instrument can't play should be
+	// clamped to the nearest playable note (see NoteRangeMode).
+	clampNotes bool
+
 	sample16bit bool
 }
 
+func (inst *instrument) isNoteMapped(fnote float64) bool {
+	i := int(fnote) - 1
+	if i < 0 || i >= 96 {
+		return false
+	}
+	return inst.noteHoles[i/64]&(1<<(i%64)) == 0
+}
+
 type envelope struct {
 	flags          xmfile.EnvelopeFlags
 	sustainPoint   uint8
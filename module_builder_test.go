@@ -0,0 +1,287 @@
+package xm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/quasilyte/xm/xmfile"
+)
+
+// testSong describes a small XM module for the tests.
+// The encode method produces the XM file bytes.
+type testSong struct {
+	name     string
+	channels int
+	tempo    int
+	bpm      int
+	restart  int
+	order    []byte
+
+	// patterns is indexed by the pattern, then the row, then the channel.
+	patterns [][][]testNote
+
+	instruments []testInstrument
+}
+
+type testNote struct {
+	note   byte
+	inst   byte
+	vol    byte
+	fx     byte
+	param  byte
+	filled bool
+}
+
+// n is a shorthand for the note with an instrument.
+func n(note, inst byte) testNote {
+	return testNote{note: note, inst: inst, filled: true}
+}
+
+// fx is a shorthand for the effect-only note.
+func fx(effect, param byte) testNote {
+	return testNote{fx: effect, param: param, filled: true}
+}
+
+type testInstrument struct {
+	name       string
+	sampleName string
+
+	// data is an 8-bit sample (absolute values, not deltas).
+	data []int8
+	// data16 is a 16-bit sample; if it's set, data is ignored.
+	// The loop bounds are specified in frames for both sample kinds.
+	data16     []int16
+	loopType   byte
+	loopStart  int
+	loopLength int
+	volume     byte
+	panning    byte
+	finetune   int8
+	relNote    int8
+
+	// keymap overrides the default keymap (all notes use the sample 0).
+	keymap []byte
+
+	volumeEnvelope []xmfile.EnvelopePoint
+	volumeFlags    xmfile.EnvelopeFlags
+	volumeSustain  byte
+	fadeout        int
+}
+
+// newTestSong creates a song with the given number of channels
+// and the patterns of the given length.
+// The song uses a single sine-like instrument.
+func newTestSong(channels int, patternRows ...int) *testSong {
+	s := &testSong{
+		name:        "test song",
+		channels:    channels,
+		tempo:       6,
+		bpm:         125,
+		instruments: []testInstrument{sineInstrument(64)},
+	}
+	for i, numRows := range patternRows {
+		s.order = append(s.order, byte(i))
+		s.patterns = append(s.patterns, emptyPattern(channels, numRows))
+	}
+	return s
+}
+
+func emptyPattern(channels, numRows int) [][]testNote {
+	p := make([][]testNote, numRows)
+	for i := range p {
+		p[i] = make([]testNote, channels)
+	}
+	return p
+}
+
+// sineInstrument returns a forward-looped sine wave instrument.
+// The period is 32 frames; the sample is numPeriods periods long.
+func sineInstrument(numPeriods int) testInstrument {
+	data := make([]int8, 32*numPeriods)
+	for i := range data {
+		data[i] = int8(100 * math.Sin(float64(i)*2*math.Pi/32))
+	}
+	return testInstrument{
+		name:       "sine",
+		sampleName: "sine wave",
+		data:       data,
+		loopType:   1,
+		loopStart:  0,
+		loopLength: len(data),
+		volume:     64,
+		panning:    128,
+	}
+}
+
+func (s *testSong) encode() []byte {
+	var b bytes.Buffer
+	le16 := func(v int) { binary.Write(&b, binary.LittleEndian, uint16(v)) }
+	le32 := func(v int) { binary.Write(&b, binary.LittleEndian, uint32(v)) }
+	str := func(s string, size int) {
+		buf := make([]byte, size)
+		copy(buf, s)
+		b.Write(buf)
+	}
+
+	str("Extended Module: ", 17)
+	str(s.name, 20)
+	b.WriteByte(0x1a)
+	str("xm tests", 20)
+	le16(0x0104)
+	le32(276)
+	le16(len(s.order))
+	le16(s.restart)
+	le16(s.channels)
+	le16(len(s.patterns))
+	le16(len(s.instruments))
+	le16(1) // Linear frequency table
+	le16(s.tempo)
+	le16(s.bpm)
+	str(string(s.order), 256)
+
+	for _, p := range s.patterns {
+		var data bytes.Buffer
+		for _, row := range p {
+			for _, note := range row {
+				if !note.filled {
+					data.WriteByte(0x80)
+					continue
+				}
+				data.WriteByte(0x80 | 0x1f)
+				data.Write([]byte{note.note, note.inst, note.vol, note.fx, note.param})
+			}
+		}
+		le32(9)
+		b.WriteByte(0)
+		le16(len(p))
+		le16(data.Len())
+		b.Write(data.Bytes())
+	}
+
+	for _, inst := range s.instruments {
+		le32(263)
+		str(inst.name, 22)
+		b.WriteByte(0)
+		le16(1)
+		le32(40)
+		keymap := make([]byte, 96)
+		copy(keymap, inst.keymap)
+		b.Write(keymap)
+		var points [24]xmfile.EnvelopePoint
+		copy(points[:], inst.volumeEnvelope)
+		for _, pt := range points {
+			le16(int(pt.X))
+			le16(int(pt.Y))
+		}
+		b.WriteByte(byte(len(inst.volumeEnvelope)))
+		b.WriteByte(0)
+		b.Write([]byte{inst.volumeSustain, 0, 0, 0, 0, 0})
+		b.WriteByte(byte(inst.volumeFlags))
+		b.WriteByte(0)
+		b.Write(make([]byte, 4)) // Vibrato
+		le16(inst.fadeout)
+		b.Write(make([]byte, 22)) // Reserved
+
+		numFrames := len(inst.data)
+		frameSize := 1
+		sampleType := inst.loopType
+		if inst.data16 != nil {
+			numFrames = len(inst.data16)
+			frameSize = 2
+			sampleType |= 0x10
+		}
+		le32(frameSize * numFrames)
+		le32(frameSize * inst.loopStart)
+		le32(frameSize * inst.loopLength)
+		b.WriteByte(inst.volume)
+		b.WriteByte(byte(inst.finetune))
+		b.WriteByte(sampleType)
+		b.WriteByte(inst.panning)
+		b.WriteByte(byte(inst.relNote))
+		b.WriteByte(0)
+		str(inst.sampleName, 22)
+		if inst.data16 != nil {
+			prev := int16(0)
+			for _, v := range inst.data16 {
+				le16(int(v - prev))
+				prev = v
+			}
+			continue
+		}
+		prev := int8(0)
+		for _, v := range inst.data {
+			b.WriteByte(byte(v - prev))
+			prev = v
+		}
+	}
+
+	return b.Bytes()
+}
+
+func (s *testSong) parse(t testing.TB) *xmfile.Module {
+	t.Helper()
+	m, err := xmfile.NewParser(xmfile.ParserConfig{NeedStrings: true}).ParseFromBytes(s.encode())
+	if err != nil {
+		t.Fatalf("parse test song: %v", err)
+	}
+	return m
+}
+
+// newTestStream returns a stream with the song loaded.
+func newTestStream(t testing.TB, song *testSong, config LoadModuleConfig) *Stream {
+	t.Helper()
+	s := NewStream()
+	if err := s.LoadModule(song.parse(t), config); err != nil {
+		t.Fatalf("load test song: %v", err)
+	}
+	return s
+}
+
+// readAll reads the stream until io.EOF.
+func readAll(t testing.TB, s *Stream) []byte {
+	t.Helper()
+	// The buffer should fit a few ticks, otherwise Read() has nothing to render.
+	buf := make([]byte, 32*1024)
+	var result []byte
+	for {
+		n, err := s.Read(buf)
+		result = append(result, buf[:n]...)
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	}
+}
+
+// pcmFrames decodes the 16-bit little endian stereo frames.
+func pcmFrames(data []byte) [][2]int16 {
+	frames := make([][2]int16, len(data)/4)
+	for i := range frames {
+		frames[i][0] = int16(binary.LittleEndian.Uint16(data[4*i:]))
+		frames[i][1] = int16(binary.LittleEndian.Uint16(data[4*i+2:]))
+	}
+	return frames
+}
+
+// peakLevel returns the max absolute sample value of the 16-bit PCM data.
+func peakLevel(data []byte) int {
+	peak := 0
+	for _, f := range pcmFrames(data) {
+		for _, v := range f {
+			peak = max(peak, int(abs(float64(v))))
+		}
+	}
+	return peak
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
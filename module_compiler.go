@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strings"
 
 	"github.com/quasilyte/xm/internal/xmdb"
 	"github.com/quasilyte/xm/xmfile"
@@ -20,20 +21,47 @@ type moduleCompiler struct {
 	samplePool []int16
 
 	subSamples bool
+
+	interpolation InterpolationMode
+
+	noteRange NoteRangeMode
 }
 
-func compileModule(m *xmfile.Module, config moduleConfig) (module, error) {
+// compileModule converts the parsed module into its playable form.
+//
+// The mem module memory is reused for the result when possible (see module.release);
+// mem should not be used after this call.
+func compileModule(m *xmfile.Module, config moduleConfig, mem module) (module, error) {
 	c := &moduleCompiler{
-		effectSet:  make(map[uint64]effectKey, 24),
-		effectBuf:  make([]xmdb.Effect, 0, 4),
-		subSamples: config.subSamples,
+		effectSet:     make(map[uint64]effectKey, 24),
+		effectBuf:     make([]xmdb.Effect, 0, 4),
+		subSamples:    config.subSamples,
+		interpolation: config.interpolation,
+		noteRange:     config.noteRange,
+	}
+	effectTab := mem.effectTab[:0]
+	if effectTab == nil {
+		effectTab = make([]noteEffect, 0, 24)
 	}
 	c.result = module{
-		sampleRate:  float64(config.sampleRate),
-		bpm:         float64(config.bpm),
-		ticksPerRow: int(config.tempo),
-		effectTab:   make([]noteEffect, 0, 24),
-		noteTab:     make([]patternNote, len(m.Notes)),
+		sampleRate:   float64(config.sampleRate),
+		bpm:          float64(config.bpm),
+		ticksPerRow:  int(config.tempo),
+		wrapAround:   config.wrapAround,
+		amigaPanning: config.amigaPanning,
+		format:       config.format,
+		frameSize:    2 * config.format.BytesPerSample(),
+		bigEndian:    config.bigEndian,
+		effectTab:    effectTab,
+		noteTab:      reuseSlice(mem.noteTab, len(m.Notes)),
+
+		instruments:     mem.instruments,
+		instrumentNames: mem.instrumentNames,
+		sampleNames:     mem.sampleNames,
+		patterns:        mem.patterns,
+		patternOrder:    mem.patternOrder,
+		sampleData:      mem.sampleData,
+		noteData:        mem.noteData,
 	}
 	err := c.compile(m)
 	return c.result, err
@@ -44,8 +72,16 @@ func (c *moduleCompiler) compile(m *xmfile.Module) error {
 		return errors.New("the Amiga frequency table is not supported yet")
 	}
 
-	c.result.samplesPerTick, c.result.bytesPerTick = calcSamplesPerTick(c.result.sampleRate, c.result.bpm)
+	// The ramping speed was tuned for 44100 Hz.
+	c.result.volumeRampStep = (1.0 / 180.0) * (44100 / c.result.sampleRate)
+	c.result.samplesPerTick, c.result.framesPerTick = calcSamplesPerTick(c.result.sampleRate, c.result.bpm)
 	c.result.secondsPerRow = calcSecondsPerRow(c.result.ticksPerRow, c.result.bpm)
+	// The tick rendering assumes that every tick can fit the volume ramping frames.
+	// Anything smaller than that is a degenerate tick that can't be rendered properly.
+	if c.result.samplesPerTick < numRampPoints {
+		return fmt.Errorf("BPM=%v with sample rate=%v results in %v samples per tick (need at least %d)",
+			c.result.bpm, c.result.sampleRate, c.result.samplesPerTick, numRampPoints)
+	}
 
 	if err := c.compileInstruments(m); err != nil {
 		return err
@@ -83,7 +119,22 @@ func (c *moduleCompiler) makeSampleBuf(l int) []int16 {
 }
 
 func (c *moduleCompiler) compileInstruments(m *xmfile.Module) error {
-	c.result.instruments = make([]instrument, m.NumInstruments)
+	c.result.instruments = reuseSlice(c.result.instruments, m.NumInstruments)
+
+	c.result.instrumentNames = reuseSlice(c.result.instrumentNames, m.NumInstruments)
+	c.result.sampleNames = reuseSlice(c.result.sampleNames, m.NumInstruments)
+	for i, rawInst := range m.Instruments {
+		c.result.instrumentNames[i] = trimName(rawInst.Name)
+		if len(rawInst.Samples) == 0 {
+			continue
+		}
+		names := make([]string, len(rawInst.Samples))
+		for j := range rawInst.Samples {
+			names[j] = trimName(rawInst.Samples[j].Name)
+		}
+		c.result.sampleNames[i] = names
+	}
+
 	for i, rawInst := range m.Instruments {
 		if len(rawInst.Samples) == 0 {
 			continue
@@ -106,7 +157,8 @@ func (c *moduleCompiler) compileInstruments(m *xmfile.Module) error {
 		combinedSampleSize += c.calculateTotalSampleSize(dstInst, &rawInst.Samples[0])
 	}
 	// This 1 allocation should be enough for all samples.
-	c.samplePool = make([]int16, combinedSampleSize)
+	c.result.sampleData = reuseSlice(c.result.sampleData, combinedSampleSize)
+	c.samplePool = c.result.sampleData
 
 	// Now we have the memory to allocate and load the samples.
 	for i := range m.Instruments {
@@ -116,6 +168,7 @@ func (c *moduleCompiler) compileInstruments(m *xmfile.Module) error {
 		}
 		dstInst := &c.result.instruments[i]
 		c.loadInstrumentSample(dstInst, &rawInst.Samples[0])
+		dstInst.updateLoopPositions()
 	}
 
 	return nil
@@ -126,8 +179,8 @@ func (c *moduleCompiler) loadInstrumentSample(inst *instrument, sample *xmfile.I
 	// We'll ignore sub-samples during the processing and then add them in a separate step.
 	// This makes the code a little bit easier to understand and less prone to nasty bugs.
 	dstSamples := c.makeSampleBuf(c.calculateTotalSampleSize(inst, sample))
-	numSamples := c.numSamples(sample)
 	sampleSize := c.calculateSampleSize(inst, sample)
+	inst.numFrames = c.numSamples(sample)
 
 	if sample.Is16bits() {
 		v := int16(0)
@@ -149,7 +202,7 @@ func (c *moduleCompiler) loadInstrumentSample(inst *instrument, sample *xmfile.I
 		}
 	}
 
-	switch sample.LoopType() {
+	switch inst.loopType {
 	case xmfile.SampleLoopNone:
 		// Make it work by making loopEnd unreachable.
 		inst.loopEnd = math.MaxInt
@@ -159,13 +212,18 @@ func (c *moduleCompiler) loadInstrumentSample(inst *instrument, sample *xmfile.I
 		// Turn ping-pong loop into a forward loop.
 		// [1 2 3 4 5] => [1 2 3 4 5 | 4 3 2]
 		// [1 2 3 4]   => [1 2 3 4 | 3 2]
+		//
+		// The mirrored part is inserted right after the loop end.
+		// If there are any samples after the loop, they're overwritten:
+		// they can't be reached during the playback anyway.
 		loopLength := int(inst.loopLength)
+		loopEnd := int(inst.loopEnd)
 		numExtraSamples := loopLength - 2
 		inst.loopLength += float64(numExtraSamples)
 		inst.loopEnd += float64(numExtraSamples)
 		for i := 0; i < numExtraSamples; i++ {
-			dstIndex := numSamples + i
-			srcIndex := numSamples - 2 - i
+			dstIndex := loopEnd + i
+			srcIndex := loopEnd - 2 - i
 			dstSamples[dstIndex] = dstSamples[srcIndex]
 		}
 	}
@@ -177,6 +235,109 @@ func (c *moduleCompiler) loadInstrumentSample(inst *instrument, sample *xmfile.I
 	}
 }
 
+// setLoopType changes the compiled instrument sample loop type.
+//
+// It's a runtime alternative to the compiler loop handling:
+// the sample frames are extracted from the compiled samples
+// and then the compiled samples are re-built for the new loop type.
+//
+// If the sample was compiled with a ping-pong loop, the frames
+// after the loop end are lost (see loadInstrumentSample).
+func (inst *instrument) setLoopType(loopType xmfile.SampleLoopType) error {
+	loopStart := inst.frameLoopStart
+	loopEnd := inst.frameLoopEnd
+	loopLength := loopEnd - loopStart
+	switch loopType {
+	case xmfile.SampleLoopNone:
+		// OK.
+	case xmfile.SampleLoopForward:
+		if loopLength <= 0 {
+			return errors.New("can't use a forward loop: the sample loop is empty")
+		}
+	case xmfile.SampleLoopPingPong:
+		if loopLength < 2 {
+			return errors.New("a ping-pong sample loop can't be shorter than 2")
+		}
+	default:
+		return errors.New("unsupported loop type")
+	}
+
+	numFrames := inst.numFrames
+	if inst.loopType == xmfile.SampleLoopPingPong {
+		numFrames = loopEnd
+	}
+	frameStep := inst.numSubSamples + 1
+	frames := make([]int16, numFrames, numFrames+loopLength)
+	for i := range frames {
+		frames[i] = inst.samples[i*frameStep]
+	}
+
+	if loopType == xmfile.SampleLoopPingPong {
+		// See loadInstrumentSample for the explanation.
+		frames = frames[:loopEnd]
+		for i := 0; i < loopLength-2; i++ {
+			frames = append(frames, frames[loopEnd-2-i])
+		}
+		loopEnd += loopLength - 2
+		loopLength += loopLength - 2
+	}
+
+	samples := frames
+	numSub := inst.numSubSamples
+	if numSub != 0 && len(frames) > 1 {
+		// This is the same interpolation that is performed by insertSubSamples.
+		samples = make([]int16, (len(frames)-1)*frameStep+1)
+		tStep := 1.0 / float64(frameStep)
+		for i := 0; i < len(frames)-1; i++ {
+			u := float64(frames[i])
+			v := float64(frames[i+1])
+			k := i * frameStep
+			samples[k] = frames[i]
+			t := tStep
+			for j := 0; j < numSub; j++ {
+				samples[k+frameStep-1-j] = int16(lerp(v, u, t))
+				t += tStep
+			}
+		}
+		samples[len(samples)-1] = frames[len(frames)-1]
+	}
+
+	inst.samples = samples
+	inst.numFrames = numFrames
+	inst.loopType = loopType
+	inst.sampleStepMultiplier = float64(len(samples)) / float64(len(frames))
+	inst.loopStart = float64(loopStart * frameStep)
+	inst.loopEnd = float64(loopEnd*frameStep - numSub)
+	inst.loopLength = float64(loopLength*frameStep - numSub)
+	if loopType == xmfile.SampleLoopNone {
+		// Make it work by making loopEnd unreachable.
+		inst.loopEnd = math.MaxInt
+	}
+	inst.updateLoopPositions()
+
+	return nil
+}
+
+// updateLoopPositions converts the loop bounds into the fixed-point
+// format that is used by the mixer (see streamChannel.NextSample).
+// It should be called after every loop bounds change.
+func (inst *instrument) updateLoopPositions() {
+	if inst.loopType == xmfile.SampleLoopNone || inst.loopLength <= 0 {
+		// Make the loop end unreachable.
+		inst.loopStartPos = 0
+		inst.loopEndPos = math.MaxUint64
+		inst.loopLengthPos = 0
+	} else {
+		inst.loopStartPos = toSamplePos(inst.loopStart)
+		inst.loopEndPos = toSamplePos(inst.loopEnd)
+		inst.loopLengthPos = toSamplePos(inst.loopLength)
+	}
+	inst.loopStartFrame = inst.loopStartPos >> samplePosFracBits
+	inst.loopEndFrame = inst.loopEndPos >> samplePosFracBits
+	inst.loopLengthFrame = inst.loopLengthPos >> samplePosFracBits
+	inst.wrapFrame = clampMax(inst.loopEndFrame, uint64(len(inst.samples)))
+}
+
 func (c *moduleCompiler) insertSubSamples(inst *instrument, sample *xmfile.InstrumentSample, sampleSize int) {
 	// Sub samples make the compiler harder, but they do make the playback faster
 
@@ -226,10 +387,10 @@ func (c *moduleCompiler) insertSubSamples(inst *instrument, sample *xmfile.Instr
 	}
 
 	inst.sampleStepMultiplier = float64(sampleSize+((sampleSize-1)*numSub)) / float64(sampleSize)
+	inst.numSubSamples = numSub
 
-	if sample.LoopType() != xmfile.SampleLoopNone {
+	if inst.loopType != xmfile.SampleLoopNone {
 		if numSub != 0 {
-			inst.numSubSamples = numSub
 			inst.loopEnd = float64(int(inst.loopEnd)*(numSub+1) - numSub)
 			inst.loopStart = float64(int(inst.loopStart) * (numSub + 1))
 			inst.loopLength = float64(int(inst.loopLength)*(numSub+1) - numSub)
@@ -264,13 +425,18 @@ func (c *moduleCompiler) compileInstrument(inst xmfile.Instrument) (instrument,
 		loopStart /= 2
 		loopLength /= 2
 	}
-	switch sample.LoopType() {
+	loopType := sample.LoopType()
+	switch loopType {
 	case xmfile.SampleLoopNone:
 		// OK.
 	case xmfile.SampleLoopForward:
 		if loopStart > loopEnd {
 			return instrument{}, errors.New("sample loopStart > loopEnd")
 		}
+		if loopLength == 0 {
+			// An empty loop can't be played; treat it as a one-shot sample.
+			loopType = xmfile.SampleLoopNone
+		}
 	case xmfile.SampleLoopPingPong:
 		if len(sample.Data) < 2 || loopLength < 2 {
 			return instrument{}, errors.New("a ping-pong sample loop can't be shorter than 2")
@@ -288,7 +454,7 @@ func (c *moduleCompiler) compileInstrument(inst xmfile.Instrument) (instrument,
 		finetune:     int8(sample.Finetune),
 		relativeNote: int8(sample.RelativeNote),
 
-		volume:  float64(sample.Volume) / 64,
+		volume:  xmVolume(sample.Volume),
 		panning: float64(sample.Panning) / 256,
 
 		volumeEnvelope:  volumeEnvelope,
@@ -296,11 +462,18 @@ func (c *moduleCompiler) compileInstrument(inst xmfile.Instrument) (instrument,
 
 		volumeFadeoutStep: float64(inst.VolumeFadeout) / 32768,
 
-		loopType:   sample.LoopType(),
+		clampNotes: c.noteRange == NoteRangeClamp,
+
+		interpolation: c.interpolation,
+
+		loopType:   loopType,
 		loopLength: float64(loopLength),
 		loopStart:  float64(loopStart),
 		loopEnd:    float64(loopEnd),
 
+		frameLoopStart: loopStart,
+		frameLoopEnd:   loopEnd,
+
 		sample16bit: sample.Is16bits(),
 	}
 
@@ -311,6 +484,17 @@ func (c *moduleCompiler) compileInstrument(inst xmfile.Instrument) (instrument,
 		return dstInst, errors.New("unknown sample loop type")
 	}
 
+	// Only one sample is compiled, so any other keymap assignment
+	// points to a sample that doesn't exist.
+	for i, sampleIndex := range inst.KeymapAssignments {
+		if i >= 96 {
+			break
+		}
+		if int(sampleIndex) >= 1 {
+			dstInst.noteHoles[i/64] |= 1 << (i % 64)
+		}
+	}
+
 	return dstInst, nil
 }
 
@@ -343,25 +527,32 @@ func (c *moduleCompiler) compileEnvelope(points []xmfile.EnvelopePoint, flags xm
 }
 
 func (c *moduleCompiler) compilePatterns(m *xmfile.Module) error {
-	c.result.patterns = make([]pattern, m.NumPatterns)
-	c.result.patternOrder = make([]*pattern, len(m.PatternOrder))
+	c.result.patterns = reuseSlice(c.result.patterns, m.NumPatterns)
+	c.result.patternOrder = reuseSlice(c.result.patternOrder, len(m.PatternOrder))
 
 	// Bind pattern order to the actual patterns.
 	for i, patternIndex := range m.PatternOrder {
 		c.result.patternOrder[i] = &c.result.patterns[patternIndex]
 	}
+	// The parser only resets the restart positions that are
+	// beyond the song length, so the one that is equal to it can get here.
+	if m.RestartPosition < len(c.result.patternOrder) {
+		c.result.restartPosition = m.RestartPosition
+	}
 
 	numNotes := 0
 	for i := range m.Patterns {
 		numNotes += len(m.Patterns[i].Rows) * m.NumChannels
 	}
 
-	noteSlicePool := make([]uint16, numNotes)
+	c.result.noteData = reuseSlice(c.result.noteData, numNotes)
+	noteSlicePool := c.result.noteData
 	noteSliceOffset := 0
 
 	for i := range m.Patterns {
 		rawPat := &m.Patterns[i]
 		pat := &c.result.patterns[i]
+		pat.id = i
 		pat.numChannels = m.NumChannels
 		pat.numRows = len(rawPat.Rows)
 
@@ -388,8 +579,11 @@ func (c *moduleCompiler) compilePatterns(m *xmfile.Module) error {
 				fnote := float64(rawNote.Note)
 				period := 0.0
 				isValid := rawNote.Note > 0 && rawNote.Note < 97
+				outOfRange := false
 				if isValid && rawNote.Instrument > 0 {
-					period = linearPeriod(calcRealNote(fnote, inst))
+					p, ok := calcNotePeriod(fnote, inst)
+					period = p
+					outOfRange = !ok
 				}
 
 				e1 := xmdb.Effect{}
@@ -426,6 +620,10 @@ func (c *moduleCompiler) compilePatterns(m *xmfile.Module) error {
 					kind = noteGhost
 				case n.flags.Contains(noteValid) && rawNote.Instrument > 0:
 					kind = noteNormal
+					if outOfRange {
+						// This instrument can't play this note.
+						kind = noteEmpty
+					}
 				default:
 					// Probably a special note like "key off".
 					kind = noteEmpty
@@ -452,12 +650,16 @@ func (c *moduleCompiler) generateNoteFlags(n *patternNote) patternNoteFlags {
 	offset := n.effect.Index()
 	for _, e := range c.result.effectTab[offset : offset+numEffects] {
 		switch e.op {
-		case xmdb.EffectNotePortamento:
+		case xmdb.EffectNotePortamento, xmdb.EffectNotePortamentoWithVolumeSlide:
 			flags |= noteHasNotePortamento
 		case xmdb.EffectArpeggio:
 			flags |= noteHasArpeggio
 		case xmdb.EffectVibrato, xmdb.EffectVibratoWithVolumeSlide:
 			flags |= noteHasVibrato
+		case xmdb.EffectTremolo:
+			flags |= noteHasTremolo
+		case xmdb.EffectNoteDelay:
+			flags |= noteHasNoteDelay
 		}
 	}
 
@@ -496,17 +698,7 @@ func (c *moduleCompiler) compileEffect(e1, e2, e3 xmdb.Effect) (effectKey, error
 
 		switch e.Op {
 		case xmdb.EffectSetVolume, xmdb.EffectSetGlobalVolume:
-			v := e.Arg
-			if v > 64 {
-				v = 64
-			}
-			compiled.floatValue = float64(v) / 64
-
-		case xmdb.EffectKeyOff:
-			if e.Arg > uint8(c.result.ticksPerRow-1) {
-				// This effect will have no effect. Discard it.
-				continue
-			}
+			compiled.floatValue = xmVolume(int(e.Arg))
 
 		case xmdb.EffectArpeggio:
 			compiled.arp[0] = 0              // The original note
@@ -520,19 +712,42 @@ func (c *moduleCompiler) compileEffect(e1, e2, e3 xmdb.Effect) (effectKey, error
 		case xmdb.EffectVolumeSlideUp, xmdb.EffectVolumeSlideDown, xmdb.EffectFineVolumeSlideUp, xmdb.EffectFineVolumeSlideDown:
 			compiled.floatValue = float64(e.Arg) / 64
 
+		case xmdb.EffectExtFineVolumeSlideUp, xmdb.EffectExtFineVolumeSlideDown:
+			compiled.floatValue = float64(e.Arg&0b1111) / 64
+
 		case xmdb.EffectPortamentoUp, xmdb.EffectPortamentoDown, xmdb.EffectNotePortamento:
 			compiled.floatValue = float64(e.Arg) * 4
 
+		case xmdb.EffectFinePortamentoUp, xmdb.EffectFinePortamentoDown:
+			compiled.floatValue = float64(e.Arg&0b1111) * 4
+
+		case xmdb.EffectExtraFinePortamentoUp, xmdb.EffectExtraFinePortamentoDown:
+			compiled.floatValue = float64(e.Arg & 0b1111)
+
+		case xmdb.EffectSetFinetune:
+			// Same as the sample finetune: [-128, 112] range.
+			compiled.floatValue = float64(int(e.Arg&0b1111)*16 - 128)
+
 		case xmdb.EffectVibrato:
-			compiled.arp[0] = e.Arg >> 4                       // speed
-			compiled.floatValue = float64(e.Arg&0b1111) / 0x0F // depth
+			compiled.arp[0] = (e.Arg >> 4) * 4 // speed (in waveform position units)
+			compiled.arp[1] = e.Arg & 0b1111   // depth
 
-		case xmdb.EffectVolumeSlide, xmdb.EffectVibratoWithVolumeSlide, xmdb.EffectGlobalVolumeSlide:
+		case xmdb.EffectSetVibratoSpeed:
+			compiled.arp[0] = e.Arg * 4
+
+		case xmdb.EffectTremolo:
+			compiled.arp[0] = (e.Arg >> 4) * 4 // speed (in waveform position units)
+			compiled.arp[1] = e.Arg & 0b1111   // depth
+
+		case xmdb.EffectMultiRetrigger:
+			compiled.arp[0] = e.Arg & 0b1111 // interval
+			compiled.arp[1] = e.Arg >> 4     // volume change mode
+
+		case xmdb.EffectVolumeSlide, xmdb.EffectNotePortamentoWithVolumeSlide, xmdb.EffectVibratoWithVolumeSlide, xmdb.EffectGlobalVolumeSlide:
+			// FastTracker II gives the slide up a priority
+			// when both up & down (XY) values are set.
 			slideUp := e.Arg >> 4
 			slideDown := e.Arg & 0b1111
-			if slideUp > 0 && slideDown > 0 {
-				return effectKey(0), errors.New("volume slide uses both up & down (XY) values")
-			}
 			if slideUp > 0 {
 				compiled.floatValue = float64(slideUp) / 64
 			} else {
@@ -540,7 +755,7 @@ func (c *moduleCompiler) compileEffect(e1, e2, e3 xmdb.Effect) (effectKey, error
 			}
 
 		case xmdb.EffectPatternBreak:
-			compiled.arp[0] = (e.Arg>>4)*10 + (e.Arg & 0b1111)
+			compiled.arp[0] = e.Arg
 
 		case xmdb.EffectSetPanning:
 			compiled.floatValue = float64(e.Arg) / 255
@@ -548,15 +763,21 @@ func (c *moduleCompiler) compileEffect(e1, e2, e3 xmdb.Effect) (effectKey, error
 		case xmdb.EffectSetBPM:
 			compiled.floatValue = float64(e.Arg)
 
-		case xmdb.EffectNoteCut:
+		case xmdb.EffectNoteCut, xmdb.EffectPatternDelay, xmdb.EffectGlissandoControl, xmdb.EffectSetVibratoControl, xmdb.EffectPatternLoop, xmdb.EffectSetTremoloControl:
+			compiled.arp[0] = e.Arg & 0b1111
+
+		case xmdb.EffectRetrigger, xmdb.EffectNoteDelay:
+			if e.Arg&0b1111 == 0 {
+				// This effect will have no effect. Discard it.
+				continue
+			}
 			compiled.arp[0] = e.Arg & 0b1111
 
 		case xmdb.EffectPanningSlide:
+			// FastTracker II gives the slide right a priority
+			// when both right & left (XY) values are set.
 			slideRight := e.Arg >> 4
 			slideLeft := e.Arg & 0b1111
-			if slideRight > 0 && slideLeft > 0 {
-				return effectKey(0), errors.New("panning slide uses both right & left (XY) values")
-			}
 			if slideRight > 0 {
 				compiled.floatValue = float64(slideRight) / 255
 			} else {
@@ -622,3 +843,8 @@ func (c *moduleCompiler) numSubSamples(sample *xmfile.InstrumentSample) int {
 		return 1
 	}
 }
+
+func trimName(s string) string {
+	// Trackers pad the names with spaces and/or zero bytes.
+	return strings.TrimRight(s, " \x00")
+}
@@ -0,0 +1,73 @@
+package xm
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	noteKeyOff = 97
+	numNotes   = 96
+)
+
+var noteNames = [12]string{
+	"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-",
+}
+
+// NoteName returns a human-readable name of the XM note value.
+//
+// The names follow the FastTracker II notation, like "C-4" or "A#5".
+// Note 1 is "C-0", note 96 is "B-7".
+//
+// An empty note (0) is named "---" and a key-off note (97) is "===".
+// Any other value out of the notes range is named "???".
+func NoteName(note int) string {
+	switch {
+	case note == 0:
+		return "---"
+	case note == noteKeyOff:
+		return "==="
+	case note < 0 || note > numNotes:
+		return "???"
+	}
+	note--
+	return noteNames[note%12] + strconv.Itoa(note/12)
+}
+
+// ParseNoteName converts a note name into the XM note value.
+// It's an inverse of NoteName, see its docs for the supported notation.
+//
+// The note letter can be either upper or lower case.
+func ParseNoteName(s string) (int, error) {
+	switch s {
+	case "---":
+		return 0, nil
+	case "===":
+		return noteKeyOff, nil
+	}
+
+	if len(s) != 3 {
+		return 0, fmt.Errorf("invalid note name %q: expected 3 characters", s)
+	}
+	letter := s[0]
+	if letter >= 'a' && letter <= 'z' {
+		letter -= 'a' - 'A'
+	}
+	key := string([]byte{letter, s[1]})
+	index := -1
+	for i, name := range noteNames {
+		if name == key {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return 0, fmt.Errorf("invalid note name %q: unknown note %q", s, key)
+	}
+	if s[2] < '0' || s[2] > '7' {
+		return 0, fmt.Errorf("invalid note name %q: octave should be in [0, 7] range", s)
+	}
+	octave := int(s[2] - '0')
+
+	return octave*12 + index + 1, nil
+}
@@ -0,0 +1,94 @@
+package xm
+
+import (
+	"github.com/quasilyte/xm/xmfile"
+)
+
+const (
+	// Only the samples up to this size are cached.
+	noteCacheMaxSampleFrames = 16 * 1024
+
+	// When the cache has this amount of entries, it's flushed.
+	noteCacheMaxEntries = 64
+)
+
+// noteCache stores the resampled one-shot notes.
+//
+// The channel can use the cached frames only if the note is played
+// from the start with a constant sample step.
+// The cached frames are generated exactly like NextSample would do it,
+// so the output is identical to the uncached playback.
+type noteCache struct {
+	entries map[noteCacheKey][]float32
+}
+
+type noteCacheKey struct {
+	inst *instrument
+	step float64
+}
+
+func newNoteCache() *noteCache {
+	return &noteCache{
+		entries: make(map[noteCacheKey][]float32, noteCacheMaxEntries),
+	}
+}
+
+func (c *noteCache) Reset() {
+	for k := range c.entries {
+		delete(c.entries, k)
+	}
+}
+
+// Update makes the channel use the cached frames when it's possible.
+// It's called on every tick after the channel sample step is calculated.
+func (c *noteCache) Update(ch *streamChannel) {
+	if ch.cached != nil {
+		if ch.sampleStep == ch.cachedStep {
+			return
+		}
+		// The pitch has changed, the cached frames are no longer valid.
+		c.Leave(ch)
+		return
+	}
+
+	if ch.samplePos != 0 || ch.samplePosStep == 0 {
+		return
+	}
+	inst := ch.inst
+	if inst.loopType != xmfile.SampleLoopNone || len(inst.samples) > noteCacheMaxSampleFrames {
+		return
+	}
+
+	key := noteCacheKey{inst: inst, step: ch.sampleStep}
+	frames, ok := c.entries[key]
+	if !ok {
+		if len(c.entries) >= noteCacheMaxEntries {
+			c.Reset()
+		}
+		frames = c.render(inst, ch.sampleStep)
+		c.entries[key] = frames
+	}
+	ch.cached = frames
+	ch.cachedFrame = 0
+	ch.cachedStep = ch.sampleStep
+}
+
+// Leave switches the channel back to the uncached playback.
+func (c *noteCache) Leave(ch *streamChannel) {
+	if ch.cached == nil {
+		return
+	}
+	// Restore the sample position the same way NextSample would calculate it.
+	// The cached samples are never looped, so it's a simple multiplication.
+	ch.SetSamplePos(uint64(ch.cachedFrame) * toSamplePos(ch.cachedStep))
+}
+
+func (c *noteCache) render(inst *instrument, step float64) []float32 {
+	// This loop mirrors the NextSample logic for one-shot samples.
+	frames := make([]float32, 0, int(float64(len(inst.samples))/step)+1)
+	posStep := toSamplePos(step)
+	for pos := uint64(0); pos>>samplePosFracBits < uint64(len(inst.samples)); pos += posStep {
+		frames = append(frames, inst.sampleAt(pos))
+	}
+	return frames
+}
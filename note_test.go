@@ -0,0 +1,55 @@
+package xm
+
+import (
+	"testing"
+)
+
+func TestNoteNameRoundTrip(t *testing.T) {
+	for note := 0; note <= noteKeyOff; note++ {
+		name := NoteName(note)
+		parsed, err := ParseNoteName(name)
+		if err != nil {
+			t.Fatalf("ParseNoteName(%q) (note %d): %v", name, note, err)
+		}
+		if parsed != note {
+			t.Fatalf("ParseNoteName(NoteName(%d)) = %d (name is %q)", note, parsed, name)
+		}
+	}
+}
+
+func TestNoteName(t *testing.T) {
+	tests := []struct {
+		note int
+		name string
+	}{
+		{0, "---"},
+		{1, "C-0"},
+		{2, "C#0"},
+		{12, "B-0"},
+		{13, "C-1"},
+		{49, "C-4"},
+		{58, "A-4"},
+		{71, "A#5"},
+		{96, "B-7"},
+		{97, "==="},
+		{98, "???"},
+		{-1, "???"},
+	}
+	for _, test := range tests {
+		if name := NoteName(test.note); name != test.name {
+			t.Errorf("NoteName(%d) = %q, want %q", test.note, name, test.name)
+		}
+	}
+}
+
+func TestParseNoteNameErrors(t *testing.T) {
+	inputs := []string{"", "C-", "C-45", "H-4", "C+4", "C-8", "C-x", "???"}
+	for _, s := range inputs {
+		if note, err := ParseNoteName(s); err == nil {
+			t.Errorf("ParseNoteName(%q) = %d, expected an error", s, note)
+		}
+	}
+	if note, err := ParseNoteName("a#5"); err != nil || note != 71 {
+		t.Errorf("ParseNoteName(%q) = %d, %v; want 71", "a#5", note, err)
+	}
+}
@@ -0,0 +1,137 @@
+package xm
+
+// reverb is a Freeverb-style stereo reverberator.
+//
+// Every side has 8 parallel low-passed feedback comb filters
+// followed by 4 series allpass filters. The right side delays
+// are slightly longer, this decorrelates the sides.
+// The delay lengths are the classic Freeverb tunings for 44100 Hz,
+// they're scaled for the other sample rates.
+type reverb struct {
+	left  reverbSide
+	right reverbSide
+
+	feedback float64
+	damping  float64
+}
+
+type reverbSide struct {
+	combs     [8]combFilter
+	allpasses [4]allpassFilter
+}
+
+type combFilter struct {
+	buf    []float64
+	pos    int
+	filter float64
+}
+
+type allpassFilter struct {
+	buf []float64
+	pos int
+}
+
+var (
+	reverbCombTunings    = [8]int{1116, 1188, 1277, 1356, 1422, 1491, 1557, 1617}
+	reverbAllpassTunings = [4]int{556, 441, 341, 225}
+)
+
+const (
+	reverbStereoSpread = 23
+	reverbInputGain    = 0.015
+	reverbOutputGain   = 1.0 // The Freeverb wet scale (3) times its default wet level (1/3)
+
+	// The values smaller than this are flushed to zero.
+	// Otherwise a decaying reverb tail would end up in the denormal
+	// numbers range that is extremely slow on some CPUs.
+	reverbSilence = 1e-15
+)
+
+func newReverb(sampleRate float64) *reverb {
+	r := &reverb{}
+	scale := sampleRate / 44100
+	initSide := func(side *reverbSide, spread int) {
+		for i := range side.combs {
+			side.combs[i].buf = make([]float64, int(float64(reverbCombTunings[i]+spread)*scale))
+		}
+		for i := range side.allpasses {
+			side.allpasses[i].buf = make([]float64, int(float64(reverbAllpassTunings[i]+spread)*scale))
+		}
+	}
+	initSide(&r.left, 0)
+	initSide(&r.right, reverbStereoSpread)
+	r.SetRoom(0.5, 0.5)
+	return r
+}
+
+// SetRoom adjusts the reverb parameters (see Stream.SetReverbRoom).
+func (r *reverb) SetRoom(size, damping float64) {
+	r.feedback = 0.7 + 0.28*size
+	r.damping = 0.4 * damping
+}
+
+func (r *reverb) Reset() {
+	r.left.Reset()
+	r.right.Reset()
+}
+
+// Process pushes the next input frame to the reverb and returns its wet output.
+func (r *reverb) Process(v float64) (left, right float64) {
+	v *= reverbInputGain
+	left = r.left.Process(v, r.feedback, r.damping)
+	right = r.right.Process(v, r.feedback, r.damping)
+	return left * reverbOutputGain, right * reverbOutputGain
+}
+
+func (side *reverbSide) Reset() {
+	for i := range side.combs {
+		c := &side.combs[i]
+		for j := range c.buf {
+			c.buf[j] = 0
+		}
+		c.pos = 0
+		c.filter = 0
+	}
+	for i := range side.allpasses {
+		a := &side.allpasses[i]
+		for j := range a.buf {
+			a.buf[j] = 0
+		}
+		a.pos = 0
+	}
+}
+
+func (side *reverbSide) Process(v, feedback, damping float64) float64 {
+	out := 0.0
+	for i := range side.combs {
+		out += side.combs[i].Process(v, feedback, damping)
+	}
+	for i := range side.allpasses {
+		out = side.allpasses[i].Process(out)
+	}
+	return out
+}
+
+func (c *combFilter) Process(v, feedback, damping float64) float64 {
+	out := c.buf[c.pos]
+	c.filter = out*(1-damping) + c.filter*damping
+	if abs(c.filter) < reverbSilence {
+		c.filter = 0
+	}
+	c.buf[c.pos] = v + c.filter*feedback
+	c.pos++
+	if c.pos == len(c.buf) {
+		c.pos = 0
+	}
+	return out
+}
+
+func (a *allpassFilter) Process(v float64) float64 {
+	delayed := a.buf[a.pos]
+	a.buf[a.pos] = v + delayed*0.5
+	a.pos++
+	if a.pos == len(a.buf) {
+		a.pos = 0
+	}
+	return delayed - v
+}
@@ -0,0 +1,105 @@
+package xm
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// SampleFormat specifies the output PCM sample encoding.
+// See LoadModuleConfig.SampleFormat.
+//
+// The output is always stereo: the frames contain the left
+// and the right channel samples (interleaved).
+// The byte order is little endian unless LoadModuleConfig.BigEndian is set.
+type SampleFormat uint8
+
+const (
+	// SampleFormatInt16 is a signed 16-bit little endian PCM.
+	// This is what Ebitengine audio package expects.
+	SampleFormatInt16 SampleFormat = iota
+
+	// SampleFormatFloat32 is a 32-bit IEEE 754 little endian PCM.
+	// The sample values are in [-1, 1] range.
+	SampleFormatFloat32
+
+	// SampleFormatInt24 is a signed 24-bit little endian PCM.
+	// Every sample takes exactly 3 bytes (it's a packed format).
+	SampleFormatInt24
+
+	// SampleFormatInt32 is a signed 32-bit little endian PCM.
+	SampleFormatInt32
+)
+
+// BytesPerSample returns the size of a single (mono) sample in bytes.
+func (f SampleFormat) BytesPerSample() int {
+	switch f {
+	case SampleFormatFloat32, SampleFormatInt32:
+		return 4
+	case SampleFormatInt24:
+		return 3
+	default:
+		return 2
+	}
+}
+
+func (f SampleFormat) isValid() bool {
+	return f <= SampleFormatInt32
+}
+
+// encodeFrames converts the mixed frames to the specified format.
+// The mix values use the 16-bit PCM scale, see Stream.mixTick.
+// dst should have enough space to hold the converted frames.
+func encodeFrames(dst []byte, mix []float64, format SampleFormat, bigEndian bool) {
+	encodeFramesLE(dst, mix, format)
+	if bigEndian {
+		swapSampleBytes(dst[:len(mix)*format.BytesPerSample()], format.BytesPerSample())
+	}
+}
+
+func encodeFramesLE(dst []byte, mix []float64, format SampleFormat) {
+	switch format {
+	case SampleFormatFloat32:
+		for i, v := range mix {
+			v = clamp(v*(1.0/32768.0), -1, 1)
+			binary.LittleEndian.PutUint32(dst[i*4:], math.Float32bits(float32(v)))
+		}
+	case SampleFormatInt24:
+		for i, v := range mix {
+			x := uint32(toInt32(v*256, -1<<23, 1<<23-1))
+			j := i * 3
+			dst[j+0] = byte(x)
+			dst[j+1] = byte(x >> 8)
+			dst[j+2] = byte(x >> 16)
+		}
+	case SampleFormatInt32:
+		for i, v := range mix {
+			binary.LittleEndian.PutUint32(dst[i*4:], uint32(toInt32(v*65536, math.MinInt32, math.MaxInt32)))
+		}
+	default:
+		for i := 0; i < len(mix); i += 2 {
+			putPCM(dst[i*2:], toInt16(mix[i]), toInt16(mix[i+1]))
+		}
+	}
+}
+
+// swapSampleBytes reverses the byte order of every sample in b.
+func swapSampleBytes(b []byte, sampleSize int) {
+	for i := 0; i < len(b); i += sampleSize {
+		sample := b[i : i+sampleSize]
+		for j, k := 0, len(sample)-1; j < k; j, k = j+1, k-1 {
+			sample[j], sample[k] = sample[k], sample[j]
+		}
+	}
+}
+
+// appendFrames is like encodeFrames, but it appends the result to dst.
+func appendFrames(dst []byte, mix []float64, format SampleFormat, bigEndian bool) []byte {
+	n := len(mix) * format.BytesPerSample()
+	if cap(dst)-len(dst) < n {
+		grown := make([]byte, len(dst), 2*cap(dst)+n)
+		copy(grown, dst)
+		dst = grown
+	}
+	encodeFrames(dst[len(dst):len(dst)+n], mix, format, bigEndian)
+	return dst[:len(dst)+n]
+}
@@ -2,8 +2,10 @@ package xm
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"math"
+	"time"
 
 	"github.com/quasilyte/xm/internal/xmdb"
 	"github.com/quasilyte/xm/xmfile"
@@ -11,9 +13,31 @@ import (
 
 // Stream wraps the compiled XM module, making it possible to Read() its PCM bytes.
 //
-// The Read() method produces 16-bit little endian PCM bytes; this is what ebiten/audio
+// The Read() method produces 16-bit little endian PCM bytes by default; this is what ebiten/audio
 // package extects. Use Stream as an io.Reader argument for audio.NewPlayer().
+// Other output formats can be selected via LoadModuleConfig.SampleFormat.
+//
+// Stream methods can be called from several goroutines; a common setup is
+// an audio goroutine calling Read while the game goroutine controls the playback.
+// The methods that don't return anything (like SetVolume, SetChannelMuted or Rewind) never block:
+// if the stream is busy, they're applied at the next tick boundary of the current Read call
+// (or when the next method call starts), in the order they were called.
+// All other methods wait for the current Read call to finish.
+// The playback handlers (like OnRow) are called during the Read call,
+// so they should only use the non-blocking methods; calling any other
+// Stream method from a handler leads to a deadlock.
+// Use NewStream to create a Stream.
 type Stream struct {
+	// ctl is never changed after the stream creation:
+	// unlike the rest of the stream, it's accessed without the lock.
+	ctl *streamControl
+
+	streamState
+}
+
+// streamState is the Stream data guarded by the stream lock.
+// Rewinding the stream resets the state object (see rewind).
+type streamState struct {
 	module module
 
 	pattern           *pattern
@@ -28,6 +52,9 @@ type Stream struct {
 	jumpPattern int
 	jumpRow     int
 
+	// The number of extra rows the current row should last (see EEx effect).
+	patternDelay int
+
 	settings streamSettings
 
 	// These values can change during the playback.
@@ -35,33 +62,145 @@ type Stream struct {
 	bpm            float64
 	samplesPerTick float64
 	ticksPerRow    int // Also known as "tempo" and "spd"
-	bytesPerTick   int
+	framesPerTick  int
 	bytePos        int // Used to report the current pos via Seek()
 	t              float64
+	tickTime       float64 // The current tick start time (used for the events)
 	secondsPerRow  float64
 
+	// Whether nextTick was already called for the tick that is not rendered yet.
+	tickPending bool
+
+	// The number of pending tick frames that should be
+	// skipped when it's mixed (used for the sample-accurate seeking).
+	tickOffset int
+
+	// The number of times the song was restarted due to the looping settings.
+	loopsDone int
+
+	// Whether the end handler was already notified about the playback end.
+	ended bool
+
+	// Whether the playback was stopped by WriteTo.
+	// Read always reports io.EOF in this state.
+	finished bool
+
+	// Fade-out state (see FadeOut).
+	// When fading is true, the stream ends after fadeFramesLeft frames.
+	fading         bool
+	fadeFrames     int
+	fadeFramesLeft int
+
 	channels       []streamChannel
 	activeChannels []*streamChannel
+
+	// rowTracker is only used during the song analysis.
+	// For the normal playback it's nil.
+	rowTracker *rowTracker
+
+	// noteCache is nil unless enabled via SetNoteCaching.
+	noteCache *noteCache
+
+	// aux is nil unless some channel has a non-zero aux send.
+	aux *auxBus
+
+	// delay is nil unless enabled via SetDelay.
+	delay *masterDelay
+
+	// meter is nil unless enabled via SetLevelMetering.
+	meter *levelMeter
+
+	// scope is nil unless enabled via SetScopeSize.
+	scope *channelScope
+
+	// mixBuf holds the current tick frames before
+	// they're encoded to the output format.
+	mixBuf []float64
+
+	// skipBuf is a scratch buffer for skipTick.
+	skipBuf []float64
+
+	// The mixChannels scratch buffers.
+	mixSamples [mixBlockFrames]float64
+	auxMix     []float64
+
+	// workers is nil unless the parallel mixing is enabled (see LoadModuleConfig.MaxWorkers).
+	workers *mixWorkers
+
+	// carry holds the encoded tick bytes that didn't fit
+	// into the Read buffer; they're returned first by the next Read.
+	// It's a slice of the tickBytes buffer.
+	carry     []byte
+	tickBytes []byte
 }
 
 type streamSettings struct {
-	volumeScaling float64
-	loop          bool
-	eventHandler  func(e StreamEvent)
+	volumeScaling    float64
+	stereoSeparation float64
+	panningLaw       PanningLaw
+	pitch            float64
+	timeScale        float64
+	loopCount        int // 0 means "no looping", a negative value loops forever
+	fadeOut          time.Duration
+	referenceMixing  bool
+	eventHandler     func(e StreamEvent)
+	tickHandler      func(order, row, tick int)
+	tickGranularity  CallbackGranularity
+	rowHandler       func(order, pattern, row int)
+	patternHandler   func(order, pattern int)
+	endHandler       func(looping bool)
+	auxSends         []float64
+	auxEffect        AuxEffect
+	reverbSize       float64
+	reverbDamping    float64
+	delay            DelayConfig
+	channelVolumes   []float64
+	channelMuted     []bool
+	channelSolo      []bool
+	numSoloChannels  int
+	channelFilters   []channelFilterParams
+	numFilters       int // The number of channels with an enabled filter
 }
 
+// CallbackGranularity specifies how often the playback progress callback is called.
+// See Stream.SetTickHandler.
+type CallbackGranularity uint8
+
+const (
+	// CallbackEveryTick calls the handler on every tick.
+	CallbackEveryTick CallbackGranularity = iota
+
+	// CallbackEveryRow calls the handler on the first tick of every row.
+	CallbackEveryRow
+
+	// CallbackEveryPattern calls the handler on the first tick of every pattern.
+	// A pattern jump (or break) is considered to be a pattern start too.
+	CallbackEveryPattern
+)
+
 type jumpKind uint8
 
 const (
 	jumpNone jumpKind = iota
 	jumpPatternBreak
+	jumpPositionJump
+	jumpPatternLoop
+)
+
+const (
+	minSampleRate = 8000
+	maxSampleRate = 384000
 )
 
 // StreamInfo contains a compiled XM module stream information like bytes per tick, etc.
 type StreamInfo struct {
 	// BytesPerTick tell how much bytes this stream needs to fit a single XM tick.
-	// This value is important, since any slice smaller than this will give no effect
-	// for Read() function. Any greater values will work OK for it.
+	// Read works with any slice size, but the slices that fit a whole
+	// number of ticks are the most efficient ones (see Stream.Read).
+	//
+	// This value is calculated for the initial BPM.
+	// The module can change the BPM during the playback (see Fxx effect),
+	// a lower BPM makes the ticks bigger.
 	BytesPerTick uint
 
 	// MemoryUsage approximates the compiled XM module size in bytes.
@@ -70,6 +209,27 @@ type StreamInfo struct {
 	MemoryUsage uint
 }
 
+// Position describes the playback position inside the song.
+// See Stream.GetPosition.
+type Position struct {
+	// Order is a pattern order index.
+	Order int
+
+	// Pattern is a pattern number that is played at the Order.
+	Pattern int
+
+	// Row is a pattern row index.
+	Row int
+
+	// Tick is a row tick index.
+	// During the pattern delay (see EEx effect), the row ticks are repeated.
+	Tick int
+
+	// Elapsed is the duration of the audio produced since
+	// the song start (or since the last loop restart).
+	Elapsed time.Duration
+}
+
 // LoadModuleConfig configures the XM module loading.
 //
 // These settings can't be changed after a module is loaded.
@@ -77,21 +237,30 @@ type StreamInfo struct {
 // Some extra configurations are available via Stream methods:
 //   - Stream.SetVolume()
 //   - Stream.SetLooping()
+//   - Stream.SetLoopCount()
+//   - Stream.SetFadeOut()
 //
 // These extra configuration methods can be used even after a module is loaded.
 type LoadModuleConfig struct {
 	// LinearInterpolation enables sub-samples that will make some music sound smoother.
 	// On average, this option will make loaded track to require ~x2 memory.
 	//
-	// The best way to figure out whether you need it or not is to listen to the results.
-	// Most XM players you can find have linear interpolation (lerp) enabled by default.
+	// The sub-samples are a precomputed alternative to the Interpolation option:
+	// it only makes sense to use them with InterpolationNone.
 	//
-	// A zero value means "no interpolation".
+	// A zero value means "no sub-samples".
 	//
 	// This should not be confused with volume ramping.
 	// The volume ramping is always enabled and can't be turned off.
 	LinearInterpolation bool
 
+	// Interpolation specifies how the sample frames are resampled
+	// to the output rate during the playback.
+	// The higher quality modes trade CPU time for the smoother sound.
+	//
+	// A zero value (InterpolationLinear) is what most XM players use by default.
+	Interpolation InterpolationMode
+
 	// BPM sets the playback speed.
 	// Higher BPM will make the music play faster.
 	//
@@ -116,18 +285,205 @@ type LoadModuleConfig struct {
 	//
 	// A zero value will assume a sample rate of 44100.
 	//
-	// Note: only two values are supported right now, 44100 and 0.
-	// Therefore, you can only play XM tracks at sample rate of 44100.
-	// This limitation can go away later.
+	// Any sample rate in [8000, 384000] range can be used;
+	// the note frequencies are computed for the specified rate,
+	// so there is no need to resample the output.
+	// Note that a low sample rate combined with a high BPM can
+	// result in ticks that are too short, LoadModule reports an error in this case.
 	SampleRate uint
+
+	// NoteRange specifies how to handle the notes that
+	// can't be played by their instruments.
+	// This includes the notes that go outside of the 10 octaves range
+	// after applying the sample relative note as well as the notes
+	// that are not mapped to any sample by the instrument keymap.
+	//
+	// A zero value (NoteRangeIgnore) matches the FastTracker II behavior.
+	NoteRange NoteRangeMode
+
+	// SampleFormat specifies the output PCM encoding.
+	//
+	// A zero value (SampleFormatInt16) produces 16-bit little endian samples.
+	// The mixing is performed with a floating point precision,
+	// so the higher bit depth formats preserve the extra resolution.
+	// The RenderRange and RenderPattern outputs use this format too.
+	SampleFormat SampleFormat
+
+	// BigEndian makes the output PCM samples use the big endian byte order
+	// (e.g. for the AIFF files).
+	// A zero value means "little endian", this is what most audio APIs expect.
+	BigEndian bool
+
+	// WrapAround makes the song play endlessly:
+	// after the last pattern order entry, the playback
+	// continues from the first one (order 0).
+	//
+	// Unlike SetLooping, this wrapping is seamless: the playback
+	// state (playing notes, effects, tempo, etc.) is not reset,
+	// so the song end is connected to its beginning without a gap.
+	// The module restart position is not used in this mode.
+	//
+	// This is useful for the background music in modules that were
+	// authored without an explicit loop (like a Bxx jump at the end).
+	// Note that Read never returns EOF when this option is enabled.
+	WrapAround bool
+
+	// AmigaPanning assigns the channels a fixed hard panning layout
+	// of the Amiga Paula chip: left, right, right, left (repeated
+	// for every 4 channels). The panning of the notes, the panning effects
+	// and the panning envelopes are ignored in this mode.
+	//
+	// This is useful for the modules converted from the MOD format;
+	// combine it with SetStereoSeparation to make the hard panning less extreme.
+	AmigaPanning bool
+
+	// MaxWorkers enables the parallel channel mixing if it's greater than 1.
+	//
+	// The channels are split into groups that are mixed on up to MaxWorkers
+	// goroutines (including the one that calls Read) and then summed.
+	// A group should have at least 8 active channels, so this only helps
+	// the modules with a lot of channels, keeping the tick mixing time
+	// well under the real-time budget on the multi-core devices.
+	// The output is identical to the sequential mixing.
+	//
+	// A zero value (or 1) disables the parallel mixing.
+	MaxWorkers int
+}
+
+// NoteRangeMode specifies how to handle the notes that can't be
+// played by their instruments. See LoadModuleConfig.NoteRange.
+type NoteRangeMode uint8
+
+const (
+	// NoteRangeIgnore treats unplayable notes as no-op:
+	// the channel keeps playing its current note (if any).
+	NoteRangeIgnore NoteRangeMode = iota
+
+	// NoteRangeClamp plays the nearest playable note instead.
+	// For unmapped notes, the only compiled instrument sample is used.
+	NoteRangeClamp
+)
+
+// InterpolationMode specifies the sample playback resampling method.
+// See LoadModuleConfig.Interpolation.
+type InterpolationMode uint8
+
+const (
+	// InterpolationLinear blends the two adjacent sample frames.
+	// It removes most of the aliasing caused by the low-rate samples.
+	InterpolationLinear InterpolationMode = iota
+
+	// InterpolationNone plays the sample frame at the integer part
+	// of the sample position, just like FastTracker II does.
+	// This is the fastest mode; it produces the "crunchy" sound
+	// some of the tracks were authored with.
+	InterpolationNone
+
+	// InterpolationCubic uses a 4-point Catmull-Rom spline.
+	// It's smoother than the linear mode at a moderate CPU cost.
+	InterpolationCubic
+
+	// InterpolationSinc uses an 8-point windowed sinc filter.
+	// It gives the best quality and it's the most expensive mode;
+	// the filter coefficients are precomputed, so it's still suitable
+	// for the real-time playback.
+	InterpolationSinc
+)
+
+// PanningLaw specifies the channel panning gains. See Stream.SetPanningLaw.
+type PanningLaw uint8
+
+const (
+	// PanningLawEqualPower uses sqrt(1-panning) and sqrt(panning) gains.
+	// The centered channels are attenuated by 3dB; the perceived
+	// loudness of a channel doesn't depend on its panning.
+	// This is what FastTracker II and MilkyTracker do.
+	PanningLawEqualPower PanningLaw = iota
+
+	// PanningLawLinear uses 1-panning and panning gains.
+	// The centered channels are attenuated by 6dB.
+	PanningLawLinear
+
+	// PanningLawBalance keeps the centered channels at full volume (0dB)
+	// and only attenuates the opposite side when the channel is panned.
+	// This is how the balance control of the hardware mixers works.
+	PanningLawBalance
+)
+
+// panningGains returns the left and right gains for the panning in [0, 1] range.
+func (law PanningLaw) panningGains(panning float64) (left, right float64) {
+	switch law {
+	case PanningLawLinear:
+		return 1 - panning, panning
+	case PanningLawBalance:
+		return math.Min(1, 2*(1-panning)), math.Min(1, 2*panning)
+	default:
+		return math.Sqrt(1.0 - panning), math.Sqrt(panning)
+	}
 }
 
+// FilterKind specifies the channel filter type. See Stream.SetChannelFilter.
+type FilterKind uint8
+
+const (
+	// FilterNone disables the filter.
+	FilterNone FilterKind = iota
+
+	// FilterLowPass attenuates the frequencies above the cutoff.
+	FilterLowPass
+
+	// FilterHighPass attenuates the frequencies below the cutoff.
+	FilterHighPass
+)
+
+// DelayConfig describes the output delay. See Stream.SetDelay.
+type DelayConfig struct {
+	// Rows and Ticks specify the delay time; they're summed.
+	// For example, with a speed of 6 ticks per row, {Rows: 1, Ticks: 3}
+	// is a 9 ticks delay (a dotted half-row delay).
+	// The negative values are treated as 0.
+	Rows  int
+	Ticks int
+
+	// Feedback is a portion of the delayed signal that is fed back
+	// into the delay, it controls the number of audible repeats.
+	// The value is clamped in [0, 0.95].
+	Feedback float64
+
+	// Mix is the delayed signal level; the dry signal level is always 1.
+	// The value is clamped in [0, 1].
+	Mix float64
+
+	// PingPong makes the repeats bounce between the left and the right sides.
+	PingPong bool
+}
+
+// AuxEffect specifies the auxiliary bus effect. See Stream.SetAuxEffect.
+type AuxEffect uint8
+
+const (
+	// AuxEcho is a stereo echo with two feedback delay lines.
+	AuxEcho AuxEffect = iota
+
+	// AuxReverb is a Freeverb-style reverb.
+	// It can place the music in a cave or a hall (see Stream.SetReverbRoom).
+	AuxReverb
+)
+
 // NewPlayer allocates a player that can load and play XM tracks.
 // Use LoadModule method to finish player initialization.
 func NewStream() *Stream {
 	return &Stream{
-		settings: streamSettings{
-			volumeScaling: 0.8,
+		ctl: &streamControl{},
+		streamState: streamState{
+			settings: streamSettings{
+				volumeScaling:    0.8,
+				stereoSeparation: 1,
+				reverbSize:       0.5,
+				reverbDamping:    0.5,
+				pitch:            1,
+				timeScale:        1,
+			},
 		},
 	}
 }
@@ -139,20 +495,511 @@ func NewStream() *Stream {
 // Events are produced when the XM track is being played.
 // Therefore, calling Read() may produce multiple events.
 //
+// f is called during the Read call, so it should only use
+// the non-blocking Stream methods (see Stream).
+//
 // Experimental: the events handling API may change significantly in the future.
 func (s *Stream) SetEventHandler(f func(e StreamEvent)) {
-	s.settings.eventHandler = f
+	s.control(func() {
+		s.settings.eventHandler = f
+	})
+}
+
+// SetTickHandler installs a playback progress listener to the stream.
+//
+// f is called with the current pattern order index, row and tick
+// with the specified granularity.
+// For high-BPM tracks, calling f every tick can be a noticeable overhead;
+// use a coarser granularity if the ticks resolution is not needed.
+//
+// Unlike the events, f is called during the playback simulation,
+// while the Read call is being executed.
+// This means that the callback runs ahead of the actual audio playback
+// as the PCM bytes are buffered by the audio device.
+// For the same reason, f should only use the non-blocking Stream methods (see Stream).
+//
+// Passing nil f removes the installed handler.
+func (s *Stream) SetTickHandler(granularity CallbackGranularity, f func(order, row, tick int)) {
+	s.control(func() {
+		s.settings.tickHandler = f
+		s.settings.tickGranularity = granularity
+	})
+}
+
+// OnRow installs a listener that is called every time a new pattern row starts playing.
+//
+// f is called with the current pattern order index, the pattern number and the row index.
+// It's called for the repeated rows too (pattern loops, jumps).
+// Like with SetTickHandler, f is called during the Read call, so it runs
+// ahead of the actual audio playback.
+//
+// Passing nil f removes the installed handler.
+func (s *Stream) OnRow(f func(order, pattern, row int)) {
+	s.control(func() {
+		s.settings.rowHandler = f
+	})
+}
+
+// OnPattern installs a listener that is called every time a new pattern starts playing.
+//
+// f is called with the current pattern order index and the pattern number.
+// A pattern jump (or break) is considered to be a pattern start too,
+// but the pattern loops (E6x effect) are not.
+// Like with SetTickHandler, f is called during the Read call, so it runs
+// ahead of the actual audio playback.
+//
+// Passing nil f removes the installed handler.
+func (s *Stream) OnPattern(f func(order, pattern int)) {
+	s.control(func() {
+		s.settings.patternHandler = f
+	})
+}
+
+// OnEnd installs a listener that is called when the song reaches its end.
+//
+// If the playback continues from the restart position (see SetLoopCount)
+// or from the first pattern order entry (see LoadModuleConfig.WrapAround),
+// f is called with looping=true.
+// When the playback is over and Read returns io.EOF, f is called with looping=false;
+// this happens only once (unless the stream is rewinded).
+// A song that ends with a fade-out (see SetFadeOut) is over when the fade-out completes.
+//
+// The song jumps (like a Bxx jump to the song start) are not considered to be the song end.
+// Like with SetTickHandler, f is called during the Read call, so it runs
+// ahead of the actual audio playback.
+//
+// Passing nil f removes the installed handler.
+func (s *Stream) OnEnd(f func(looping bool)) {
+	s.control(func() {
+		s.settings.endHandler = f
+	})
 }
 
 // SetVolume adjusts the global volume scaling for the stream.
 // The default value is 0.8; a value of 0 disables the sound.
 // The value is clamped in [0, 1].
 func (s *Stream) SetVolume(v float64) {
-	s.settings.volumeScaling = clamp(v, 0, 1)
+	s.control(func() {
+		s.settings.volumeScaling = clamp(v, 0, 1)
+	})
+}
+
+// SetStereoSeparation adjusts the stereo width of the output.
+//
+// The channels panning is scaled towards the center by this factor:
+// a value of 1 (the default) keeps the module panning as is,
+// a value of 0 puts all channels to the center (a mono output).
+// The fully panned channels can sound harsh on headphones,
+// values around 0.5-0.7 make such tracks more comfortable to listen.
+// The value is clamped in [0, 1].
+func (s *Stream) SetStereoSeparation(v float64) {
+	s.control(func() {
+		s.settings.stereoSeparation = clamp(v, 0, 1)
+	})
+}
+
+// SetPanningLaw selects how the channel panning is converted
+// into the left and right output gains.
+// The default is PanningLawEqualPower.
+//
+// Different players use different laws, so the same module can have
+// different levels of the centered channels; use this method to match them.
+func (s *Stream) SetPanningLaw(law PanningLaw) {
+	s.control(func() {
+		s.settings.panningLaw = law
+	})
 }
 
-// SetLooping enables a simple looping from the beginning of the stream.
-// When looping is enables, Read will never return EOF.
+// SetPitch sets the playback pitch multiplier for all channels.
+//
+// A value of 2 makes every note sound an octave higher,
+// a value of 0.5 makes it an octave lower.
+// Only the pitch is affected; the playback speed (BPM and tempo)
+// stays the same (see SetBPM to change it).
+// This is useful for the slow-motion and fast-forward effects.
+//
+// The default value is 1.
+// The value is clamped in [1/16, 16].
+func (s *Stream) SetPitch(multiplier float64) {
+	s.control(func() {
+		s.settings.pitch = clamp(multiplier, 1.0/16.0, 16)
+	})
+}
+
+// SetTimeScale sets the playback speed multiplier.
+//
+// Unlike SetPitch, it doesn't resample the notes:
+// the ticks are made shorter or longer instead, so the music
+// plays faster or slower while the notes keep their pitch.
+// A value of 2 makes the song play twice as fast.
+// The multiplier is applied on top of the module BPM,
+// so it's preserved when the module changes its BPM (see Fxx effect).
+// This is useful to speed the music up or slow it down for the gameplay reasons.
+//
+// The default value is 1.
+// The value is clamped in [0.25, 4].
+// With a low sample rate and a high BPM, the scaled ticks can become
+// too short to be rendered (see SetBPM); such ticks are clamped
+// to the shortest possible length, so the music plays slower than requested.
+func (s *Stream) SetTimeScale(multiplier float64) {
+	s.control(func() {
+		s.settings.timeScale = clamp(multiplier, 0.25, 4)
+		if s.bpm != 0 {
+			s.setBPM(s.bpm)
+			s.reserveTickBuffers(s.bpm)
+		}
+	})
+}
+
+// SetReferenceMixing enables a mixing mode that is suitable for the
+// output comparison with other XM players (like MilkyTracker or libxm).
+//
+// In this mode, every channel is mixed at unit gain:
+//
+//	out = sample * volume * fadeout * envelope * globalVolume * panGain
+//
+// Where sample is a signed 16-bit sample value, volume/fadeout/envelope/globalVolume
+// are in [0, 1] range and panGain is sqrt(1-panning) for the left channel and
+// sqrt(panning) for the right channel (an equal-power panning law, see SetPanningLaw).
+// The channel outputs are summed without any extra scaling.
+//
+// The heuristic attenuation that is used to avoid clipping
+// is disabled and the SetVolume scaling is ignored.
+// The soft clipping is disabled too: the output is hard-clipped (saturated).
+// As a consequence, loud tracks may clip in this mode.
+// Use it for testing and debugging, not for the actual playback.
+func (s *Stream) SetReferenceMixing(enabled bool) {
+	s.control(func() {
+		s.settings.referenceMixing = enabled
+	})
+}
+
+// SetNoteCaching enables or disables the note rendering cache.
+//
+// When the same instrument plays the same note over and over
+// (which is common for drums, like kicks and hi-hats), the resampled
+// note frames are identical every time.
+// With caching enabled, such notes are resampled only once.
+// Only short one-shot (non-looping) samples are cached.
+// A note stops using the cache as soon as its pitch changes
+// (due to vibrato, portamento, arpeggio, etc.)
+//
+// The cached output is identical to the uncached one.
+// Note that filling the cache allocates memory during the Read calls.
+func (s *Stream) SetNoteCaching(enabled bool) {
+	s.control(func() {
+		if !enabled {
+			if s.noteCache != nil {
+				for i := range s.channels {
+					s.noteCache.Leave(&s.channels[i])
+				}
+			}
+			s.noteCache = nil
+			return
+		}
+		if s.noteCache == nil {
+			s.noteCache = newNoteCache()
+		}
+	})
+}
+
+// SetLevelMetering enables or disables the output level metering.
+//
+// When enabled, the peak and RMS levels of every channel and
+// the master output are tracked during the playback.
+// Use GetLevels to get the current levels.
+// The metering makes the playback a bit slower, so it's disabled by default.
+func (s *Stream) SetLevelMetering(enabled bool) {
+	s.control(func() {
+		if !enabled {
+			s.meter = nil
+			return
+		}
+		if s.meter == nil {
+			s.meter = newLevelMeter(len(s.channels))
+		}
+	})
+}
+
+// GetLevels returns the current output levels for the VU meters.
+//
+// The levels are updated once per tick; when the signal goes silent,
+// they decay over time (instead of dropping to zero instantly).
+// The levels are measured while the Read call is being executed,
+// so they run ahead of the actual audio playback.
+//
+// Unless the metering is enabled via SetLevelMetering, all levels are zero.
+func (s *Stream) GetLevels() Levels {
+	s.lock()
+	defer s.unlock()
+
+	if s.meter == nil {
+		return Levels{Channels: make([]Level, len(s.channels))}
+	}
+	return s.meter.Levels()
+}
+
+// SetScopeSize enables the per-channel oscilloscope buffers.
+//
+// When enabled, the mixer keeps the last numFrames output frames of every channel.
+// Use ChannelScope to get them (e.g. for the classic tracker scope displays).
+// The channel output is recorded after the volume and panning are applied
+// (as a mono signal), but before the master effects.
+//
+// A zero numFrames disables the scopes (the default).
+// Like the level metering, the scopes make the playback a bit slower.
+func (s *Stream) SetScopeSize(numFrames int) {
+	s.control(func() {
+		if numFrames <= 0 {
+			s.scope = nil
+			return
+		}
+		if s.scope == nil || s.scope.size != numFrames {
+			s.scope = newChannelScope(len(s.channels), numFrames)
+		}
+	})
+}
+
+// ChannelScope appends the recent channel output frames to dst and returns the extended slice.
+//
+// The frames are ordered from the oldest to the newest one,
+// the values are in [-1, 1] range.
+// The number of frames is specified by SetScopeSize;
+// if the scopes are not enabled, dst is returned unchanged.
+// The frames are recorded during the Read call, so they run
+// ahead of the actual audio playback.
+//
+// The channel is a zero-based index; for out of range channels dst is returned unchanged.
+func (s *Stream) ChannelScope(channel int, dst []float32) []float32 {
+	s.lock()
+	defer s.unlock()
+
+	if s.scope == nil || channel < 0 || channel >= len(s.channels) {
+		return dst
+	}
+	return s.scope.AppendChannel(dst, channel)
+}
+
+// SetChannelAuxSend routes a portion of the channel signal to the auxiliary bus.
+//
+// The auxiliary bus is processed by the effect post-stage (see SetAuxEffect)
+// and then mixed back with the dry signal. This makes it possible to
+// add an echo only to some of the channels (e.g. only for the lead).
+//
+// The amount is clamped in [0, 1]; a value of 0 disables the send (the default).
+// The channel is a zero-based index; out of range channels are ignored.
+// The sends are preserved when a new module is loaded.
+func (s *Stream) SetChannelAuxSend(channel int, amount float64) {
+	s.control(func() {
+		if channel < 0 || channel >= len(s.channels) {
+			return
+		}
+		s.settings.auxSends = growChannelSettings(s.settings.auxSends, len(s.channels))
+		amount = clamp(amount, 0, 1)
+		s.settings.auxSends[channel] = amount
+		if amount != 0 && s.aux == nil {
+			s.aux = s.newAuxBus()
+		}
+	})
+}
+
+// SetAuxEffect selects the effect of the auxiliary bus (see SetChannelAuxSend).
+// The default is AuxEcho.
+//
+// The effect can be changed during the playback;
+// the tail of the previous effect is cut.
+func (s *Stream) SetAuxEffect(effect AuxEffect) {
+	s.control(func() {
+		s.settings.auxEffect = effect
+		if s.aux != nil {
+			s.aux.SetEffect(effect, s.settings.reverbSize, s.settings.reverbDamping)
+		}
+	})
+}
+
+// SetReverbRoom adjusts the AuxReverb effect (see SetAuxEffect).
+//
+// The size controls the reverb tail length: 0 is a small room, 1 is a large hall.
+// The damping controls how fast the high frequencies decay:
+// a stone cave has a low damping, a room with soft walls has a high one.
+// Both values are clamped in [0, 1]; the default is 0.5 for both.
+func (s *Stream) SetReverbRoom(size, damping float64) {
+	s.control(func() {
+		s.settings.reverbSize = clamp(size, 0, 1)
+		s.settings.reverbDamping = clamp(damping, 0, 1)
+		if s.aux != nil {
+			s.aux.SetEffect(s.settings.auxEffect, s.settings.reverbSize, s.settings.reverbDamping)
+		}
+	})
+}
+
+// SetDelay configures the stereo delay of the mixed output.
+//
+// The delay time is measured in the song rows and ticks, so the echoes
+// stay in sync with the music: when the song changes its tempo or BPM,
+// the delay time follows. A zero delay time disables the delay (the default).
+//
+// The delay memory is reserved for the slowest tempo and BPM that
+// the module can set (see Fxx effect), so Read doesn't allocate
+// when the song slows down. For a single row delay, it's about 1.7MB at 44100 Hz.
+// The delay can be re-configured during the playback.
+func (s *Stream) SetDelay(config DelayConfig) {
+	s.control(func() {
+		config.Rows = clampMin(config.Rows, 0)
+		config.Ticks = clampMin(config.Ticks, 0)
+		config.Feedback = clamp(config.Feedback, 0, 0.95)
+		config.Mix = clamp(config.Mix, 0, 1)
+		s.settings.delay = config
+		if config.Rows == 0 && config.Ticks == 0 {
+			s.delay = nil
+			return
+		}
+		if s.delay == nil {
+			s.delay = newMasterDelay(config)
+		}
+		s.delay.config = config
+		if s.bpm != 0 {
+			s.reserveTickBuffers(s.bpm)
+		}
+		s.delay.Sync(s.ticksPerRow, s.samplesPerTick)
+	})
+}
+
+// newAuxBus creates the auxiliary bus for the current module sample rate
+// and the stream settings.
+func (s *Stream) newAuxBus() *auxBus {
+	aux := newAuxBus(s.module.sampleRate)
+	aux.SetEffect(s.settings.auxEffect, s.settings.reverbSize, s.settings.reverbDamping)
+	return aux
+}
+
+// SetChannelVolume sets the volume scaling for the specified channel.
+//
+// The channel volume is multiplied by v, so it's possible to
+// rebalance the channels without editing the XM file
+// (e.g. to duck the lead while the dialog plays).
+// The default value is 1; a value of 0 makes the channel silent.
+// Values above 1 amplify the channel (this may cause the clipping).
+// Negative values are treated as 0.
+//
+// The channel is a zero-based index; out of range channels are ignored.
+// The volumes are preserved when a new module is loaded.
+func (s *Stream) SetChannelVolume(channel int, v float64) {
+	s.control(func() {
+		if channel < 0 || channel >= len(s.channels) {
+			return
+		}
+		n := len(s.settings.channelVolumes)
+		s.settings.channelVolumes = growChannelSettings(s.settings.channelVolumes, len(s.channels))
+		for i := n; i < len(s.settings.channelVolumes); i++ {
+			s.settings.channelVolumes[i] = 1
+		}
+		s.settings.channelVolumes[channel] = clampMin(v, 0)
+	})
+}
+
+// SetChannelMuted mutes or unmutes the specified channel.
+//
+// A muted channel is silent, but it's still being played:
+// unmuting it resumes the sound as if it was never muted.
+// This is useful for the adaptive music (e.g. adding the drums
+// when the action starts) and for the tracks debugging.
+//
+// The channel is a zero-based index; out of range channels are ignored.
+// The muted state is preserved when a new module is loaded.
+func (s *Stream) SetChannelMuted(channel int, muted bool) {
+	s.control(func() {
+		if channel < 0 || channel >= len(s.channels) {
+			return
+		}
+		s.settings.channelMuted = growChannelSettings(s.settings.channelMuted, len(s.channels))
+		s.settings.channelMuted[channel] = muted
+	})
+}
+
+// SetChannelSolo enables or disables the solo mode for the specified channel.
+//
+// While there is at least one solo channel, all other channels are silent.
+// Several channels can be in the solo mode at the same time.
+// A muted channel stays silent even if it's in the solo mode.
+//
+// The channel is a zero-based index; out of range channels are ignored.
+// The solo state is preserved when a new module is loaded.
+func (s *Stream) SetChannelSolo(channel int, solo bool) {
+	s.control(func() {
+		if channel < 0 || channel >= len(s.channels) {
+			return
+		}
+		s.settings.channelSolo = growChannelSettings(s.settings.channelSolo, len(s.channels))
+		if s.settings.channelSolo[channel] == solo {
+			return
+		}
+		s.settings.channelSolo[channel] = solo
+		if solo {
+			s.settings.numSoloChannels++
+		} else {
+			s.settings.numSoloChannels--
+		}
+	})
+}
+
+// SetChannelFilter enables a low-pass or a high-pass filter for the specified channel.
+//
+// The cutoff is a filter frequency in Hz; it's clamped to [10, 0.45*sampleRate].
+// The resonance in [0, 1] range adds a peak around the cutoff frequency:
+// 0 is a flat response, 1 is a very pronounced (whistling) peak.
+// Use FilterNone to disable the filter (the default).
+//
+// The filter parameters can be changed on every tick, this makes
+// it possible to do the classic filter sweeps. A low-pass filter
+// on all channels is a cheap way to make the music sound muffled
+// (e.g. for the underwater levels or a pause menu).
+//
+// The channel is a zero-based index; out of range channels are ignored.
+// The filters are preserved when a new module is loaded.
+func (s *Stream) SetChannelFilter(channel int, kind FilterKind, cutoff, resonance float64) {
+	s.control(func() {
+		if channel < 0 || channel >= len(s.channels) {
+			return
+		}
+		s.settings.channelFilters = growChannelSettings(s.settings.channelFilters, len(s.channels))
+		prev := s.settings.channelFilters[channel]
+		if prev.kind != FilterNone {
+			s.settings.numFilters--
+		}
+		if kind != FilterNone {
+			s.settings.numFilters++
+		}
+		s.settings.channelFilters[channel] = channelFilterParams{
+			kind:      kind,
+			cutoff:    cutoff,
+			resonance: clamp(resonance, 0, 1),
+		}
+	})
+}
+
+// isChannelAudible reports whether the channel is not silenced
+// by the mute and solo settings.
+func (s *Stream) isChannelAudible(channel int) bool {
+	if channel < len(s.settings.channelMuted) && s.settings.channelMuted[channel] {
+		return false
+	}
+	if s.settings.numSoloChannels != 0 {
+		return channel < len(s.settings.channelSolo) && s.settings.channelSolo[channel]
+	}
+	return true
+}
+
+// SetLooping makes the song restart after its end.
+// When looping is enabled, Read will never return EOF.
+//
+// The playback state is reset on every loop, but the song continues
+// from the module restart position instead of the first pattern order entry
+// (the restart position is 0 for most of the modules).
+// The effects state at the restart position (tempo, BPM, global volume, etc.)
+// is the same as it was when that position was played for the first time.
+// The playback position reported by Seek and Position goes back
+// to the restart position offset as well.
 //
 // Note that some XM tracks include the trailing jump/pattern break
 // effect that will make it loop in a more beautiful way.
@@ -162,8 +1009,136 @@ func (s *Stream) SetVolume(v float64) {
 //
 // Note: prefer this option to the InfiniteLoop provided by Ebitengine audio.
 // This native way of looping is ~free while InfiniteLoop has some overhead.
+//
+// SetLooping(true) is identical to SetLoopCount(-1) and
+// SetLooping(false) is identical to SetLoopCount(0).
 func (s *Stream) SetLooping(loop bool) {
-	s.settings.loop = loop
+	if loop {
+		s.SetLoopCount(-1)
+	} else {
+		s.SetLoopCount(0)
+	}
+}
+
+// SetLoopCount specifies how many times the song is restarted after its end.
+//
+// A value of 0 plays the song once (the default).
+// A positive n plays the song n extra times, so it's played n+1 times in total;
+// Read returns io.EOF after the last iteration ends.
+// A negative n loops the song forever.
+//
+// The loops are performed the same way as with SetLooping.
+// Use LoopsDone to get the number of performed restarts.
+func (s *Stream) SetLoopCount(n int) {
+	s.control(func() {
+		if n < 0 {
+			n = -1
+		}
+		s.settings.loopCount = n
+	})
+}
+
+// SetFadeOut enables a fade-out ending of the specified duration.
+//
+// When the song ends and there are no loop iterations left (see SetLoopCount),
+// it's restarted one more time and its volume is faded out during d.
+// Read returns io.EOF when the fade-out is complete (or when the song ends, whichever is first).
+// This gives a clean ending to the tracks that would stop abruptly otherwise.
+//
+// A zero d disables the fade-out ending (the default).
+// For the streams that loop forever, use FadeOut instead.
+func (s *Stream) SetFadeOut(d time.Duration) {
+	s.control(func() {
+		if d < 0 {
+			d = 0
+		}
+		s.settings.fadeOut = d
+	})
+}
+
+// FadeOut starts fading out the stream volume right away.
+// Read returns io.EOF after d of the audio is produced.
+//
+// This is the way to give a clean ending to the streams that loop forever.
+// A non-positive d stops the playback immediately.
+// The fade-out is cancelled by Rewind.
+func (s *Stream) FadeOut(d time.Duration) {
+	s.control(func() {
+		s.fadeOut(d)
+	})
+}
+
+func (s *Stream) fadeOut(d time.Duration) {
+	s.fading = true
+	s.fadeFrames = int(d.Seconds() * s.module.sampleRate)
+	if s.fadeFrames < 0 {
+		s.fadeFrames = 0
+	}
+	s.fadeFramesLeft = s.fadeFrames
+}
+
+// LoopsDone reports how many times the song was restarted due to the looping settings.
+//
+// The counter is reset by Rewind and LoadModule.
+// The jumps inside the song (like a Bxx jump at the end) and
+// the WrapAround mode are not counted as loops here.
+func (s *Stream) LoopsDone() int {
+	s.lock()
+	defer s.unlock()
+
+	return s.loopsDone
+}
+
+// TriggerNote starts playing a note on the specified channel outside of the pattern playback.
+//
+// This is useful for the instrument browsers and similar tools that
+// need to "audition" instruments while the song itself is paused.
+// The triggered note is rendered by the subsequent Read calls,
+// so the stream should be read as usual.
+//
+// The instrument is a zero-based index, it matches the instrument IDs
+// reported by the EventNote events.
+// The note is an XM note value in [1, 96] range (1 is C-0, 49 is C-4).
+//
+// The triggered note is overridden by the subsequent pattern rows:
+// any non-empty row note on this channel will replace it.
+// Row effects will also affect the triggered note, like they would do
+// with any other note playing on that channel.
+// Rewinding the stream stops all triggered notes.
+func (s *Stream) TriggerNote(instrument, note, channel int) error {
+	s.lock()
+	defer s.unlock()
+
+	if channel < 0 || channel >= len(s.channels) {
+		return errors.New("channel index is out of range")
+	}
+	if instrument < 0 || instrument >= len(s.module.instruments) {
+		return errors.New("instrument index is out of range")
+	}
+	if note < 1 || note > 96 {
+		return errors.New("note value is out of range")
+	}
+	inst := &s.module.instruments[instrument]
+	if len(inst.samples) == 0 {
+		return errors.New("instrument has no samples")
+	}
+
+	fnote := float64(note)
+	period, ok := calcNotePeriod(fnote, inst)
+	if !ok {
+		return errors.New("instrument can't play this note")
+	}
+
+	ch := &s.channels[channel]
+	ch.triggeredNote = patternNote{
+		inst:   inst,
+		raw:    fnote,
+		period: period,
+		flags:  noteValid | noteInitialized | (patternNoteFlags(noteNormal) << (64 - 2)),
+	}
+	ch.assignNote(&ch.triggeredNote)
+
+	return nil
 }
 
 // LoadModule assigns a new XM module to this stream.
@@ -171,11 +1146,24 @@ func (s *Stream) SetLooping(loop bool) {
 // Loading a module involves its compilation which is a slow process.
 // You want to load modules as rarely as possible (preferably exactly once)
 // and then play them via streams without ever releasing the memory.
+// The memory of the previously loaded module is reused (see Unload).
+//
+// If the module compilation fails, the stream is left with no module loaded.
 func (s *Stream) LoadModule(m *xmfile.Module, config LoadModuleConfig) error {
+	s.lock()
+	defer s.unlock()
+
 	s.applyConfigDefaults(m, &config)
 
-	if config.SampleRate != 44100 {
-		return errors.New("unsupported sample rate (only 44100 is supported)")
+	if !config.SampleFormat.isValid() {
+		return errors.New("unsupported sample format")
+	}
+	if config.Interpolation > InterpolationSinc {
+		return errors.New("unsupported interpolation mode")
+	}
+	if config.SampleRate < minSampleRate || config.SampleRate > maxSampleRate {
+		return fmt.Errorf("unsupported sample rate %d (expected a value in [%d, %d] range)",
+			config.SampleRate, minSampleRate, maxSampleRate)
 	}
 
 	if cap(s.channels) < m.NumChannels {
@@ -186,22 +1174,77 @@ func (s *Stream) LoadModule(m *xmfile.Module, config LoadModuleConfig) error {
 	s.activeChannels = s.activeChannels[:0]
 
 	compiled, err := compileModule(m, moduleConfig{
-		sampleRate: config.SampleRate,
-		bpm:        config.BPM,
-		tempo:      config.Tempo,
-		subSamples: config.LinearInterpolation,
-	})
+		sampleRate:    config.SampleRate,
+		bpm:           config.BPM,
+		tempo:         config.Tempo,
+		subSamples:    config.LinearInterpolation,
+		interpolation: config.Interpolation,
+		noteRange:     config.NoteRange,
+		wrapAround:    config.WrapAround,
+		amigaPanning:  config.AmigaPanning,
+		format:        config.SampleFormat,
+		bigEndian:     config.BigEndian,
+	}, s.module.release())
 	if err != nil {
+		// The previous module memory could be partially overwritten,
+		// so the stream can't continue playing it.
+		s.module = compiled.release()
+		s.channels = s.channels[:0]
+		s.rewind()
 		return err
 	}
+	prevSampleRate := s.module.sampleRate
 	s.module = compiled
+	if s.workers != nil && len(s.workers.tasks)+1 != config.MaxWorkers {
+		s.workers.Stop()
+		s.workers = nil
+	}
+	if s.workers == nil && config.MaxWorkers > 1 {
+		s.workers = newMixWorkers(config.MaxWorkers)
+	}
+	if s.noteCache != nil {
+		s.noteCache.Reset()
+	}
+	if s.aux != nil && prevSampleRate != s.module.sampleRate {
+		// The delay lines length depends on the sample rate.
+		s.aux = s.newAuxBus()
+	}
+	if s.meter != nil && len(s.meter.channels) != len(s.channels) {
+		s.meter = newLevelMeter(len(s.channels))
+	}
+	if s.scope != nil && len(s.scope.frames) != len(s.channels)*s.scope.size {
+		s.scope = newChannelScope(len(s.channels), s.scope.size)
+	}
 
 	// Call a rewind() that won't trigger a Sync event.
 	s.rewind()
+	s.reserveTickBuffers(s.module.bpm)
 
 	return nil
 }
 
+// Unload stops the playback and releases the loaded module.
+//
+// The stream memory (like the compiled patterns and samples buffers)
+// is kept, so the next LoadModule call can reuse it.
+// This makes it cheap to switch the music tracks with a single stream;
+// note that the stream holds the memory of its largest module.
+// The stream settings are preserved.
+//
+// After this call, the stream behaves like the one that has no module loaded:
+// Read returns io.EOF until a new module is loaded.
+func (s *Stream) Unload() {
+	s.control(func() {
+		s.module = s.module.release()
+		s.channels = s.channels[:0]
+		s.activeChannels = s.activeChannels[:0]
+		if s.noteCache != nil {
+			s.noteCache.Reset()
+		}
+		s.rewind()
+	})
+}
+
 func (s *Stream) applyConfigDefaults(m *xmfile.Module, config *LoadModuleConfig) {
 	if config.SampleRate == 0 {
 		config.SampleRate = 44100
@@ -220,75 +1263,443 @@ func (s *Stream) applyConfigDefaults(m *xmfile.Module, config *LoadModuleConfig)
 	}
 }
 
-// Seek partially implements io.Seeker.
+// Seek implements io.Seeker.
 //
-// You can use it for two things:
-//  1. (0, SeekStart) for rewind
-//  2. (0, SeekCurrent) to get the byte pos inside the stream
+// The offset is measured in the output PCM bytes (see Read).
+// It's rounded down to the frame boundary; the returned
+// position is always a multiple of the frame size.
+// The positioning is sample-accurate: the playback state is simulated
+// up to the requested position (see SeekDuration), so it can be relatively
+// slow for long songs. Two cases are special-cased to be cheap:
+//  1. (0, SeekStart) rewinds the stream
+//  2. (0, SeekCurrent) reports the byte pos inside the stream
+//
+// The io.SeekEnd offsets are relative to the song end as reported by Duration.
+// Seeking past the song end puts the stream to the end,
+// so the next Read call behaves like the song is over.
 func (s *Stream) Seek(offset int64, whence int) (int64, error) {
+	s.lock()
+	defer s.unlock()
+
+	frameSize := int64(s.module.frameSize)
+	var pos int64
 	switch whence {
 	case io.SeekStart:
-		if offset == 0 {
-			s.Rewind()
-			return 0, nil
-		}
-
+		pos = offset
 	case io.SeekCurrent:
 		if offset == 0 {
 			return int64(s.bytePos), nil
 		}
+		pos = int64(s.bytePos) + offset
+	case io.SeekEnd:
+		pos = int64(s.songFrames())*frameSize + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if pos == 0 || pos < frameSize {
+		s.syncRewind()
+		return 0, nil
+	}
+	if frameSize == 0 {
+		return 0, errors.New("no module is loaded")
+	}
+
+	t := s.t
+	frame, _ := s.seekFrame(int(pos / frameSize))
+	s.emitSeekSync(t, frame)
+	return int64(s.bytePos), nil
+}
+
+// SeekDuration moves the playback position to the specified time offset.
+//
+// The playback state (tempo, effects, playing notes, etc.) is simulated
+// from the song start up to the requested position without mixing the audio,
+// so the playback continues exactly like it would without the seek.
+// This is useful to resume the music from a saved position.
+// The positioning is sample-accurate.
+//
+// The events are not emitted for the skipped part of the song;
+// a single EventSync is emitted instead.
+// The loop counter and the fade-out state are reset.
+//
+// If d goes past the song end, an error is returned and the stream is rewinded.
+// For songs with endless loops, the song never ends, so any
+// position can be reached (but the simulation time is proportional to d).
+func (s *Stream) SeekDuration(d time.Duration) error {
+	s.lock()
+	defer s.unlock()
+
+	if d < 0 {
+		return errors.New("negative seek offset")
+	}
+
+	t := s.t
+	pos, ok := s.seekFrame(s.durationToFrames(d))
+	var err error
+	if !ok {
+		s.rewind()
+		pos = 0
+		err = errors.New("seek offset is beyond the song end")
+	}
+	s.emitSeekSync(t, pos)
+
+	return err
+}
+
+// SeekTo moves the playback position to the beginning of the specified
+// pattern order entry row.
+//
+// If that row is reachable during the normal playback, the playback state
+// is simulated from the song start up to that row without mixing the audio
+// (see SeekDuration); the first playback of that row is used.
+// Otherwise (e.g. the row is always skipped by a pattern break),
+// the playback state is reset and the playback starts from that row
+// as if the song started there.
+//
+// The events are not emitted for the skipped part of the song;
+// a single EventSync is emitted instead.
+// For the unreachable rows it syncs the time to 0.
+// The loop counter and the fade-out state are reset.
+func (s *Stream) SeekTo(order, row int) error {
+	s.lock()
+	defer s.unlock()
+
+	if order < 0 || order >= len(s.module.patternOrder) {
+		return errors.New("pattern order index is out of range")
+	}
+	if row < 0 || row >= s.module.patternOrder[order].numRows {
+		return errors.New("pattern row index is out of range")
+	}
+
+	t := s.t
+	// The row tracker makes the simulation stop for the endless loops.
+	s.rowTracker = newRowTracker(len(s.module.patternOrder))
+	pos, ok := s.fastForward(func(int) bool {
+		return s.tickIndex == 0 && s.patternIndex == order && s.patternRowIndex == row
+	})
+	s.rowTracker = nil
+	if !ok {
+		s.rewind()
+		pos = 0
+		s.jumpKind = jumpPositionJump
+		s.jumpPattern = order
+		s.jumpRow = row
+	}
+	s.emitSeekSync(t, pos)
+
+	return nil
+}
+
+// seekFrame moves the playback position to the specified output frame.
+// If that frame is beyond the song end, the stream is left at the end.
+//
+// It returns the reached frame and whether the requested frame was reached.
+func (s *Stream) seekFrame(targetFrame int) (int, bool) {
+	pos, ok := s.fastForward(func(pos int) bool {
+		return pos+s.framesPerTick > targetFrame
+	})
+	if !ok {
+		return pos, false
+	}
+	// The pending tick is rendered partially.
+	s.tickOffset = targetFrame - pos
+	s.bytePos = targetFrame * s.module.frameSize
+	return targetFrame, true
+}
+
+// fastForward rewinds the stream and then simulates the playback
+// without mixing the audio until stop returns true.
+// stop is called for every tick with the number of frames skipped so far.
+// The tick that stopped the simulation is left pending, so it's rendered
+// by the next Read call.
+//
+// It returns the number of skipped frames and whether the stop condition was met.
+// None of the handlers are called during the simulation.
+func (s *Stream) fastForward(stop func(pos int) bool) (int, bool) {
+	settings := s.settings
+	s.settings.eventHandler = nil
+	s.settings.tickHandler = nil
+	s.settings.rowHandler = nil
+	s.settings.patternHandler = nil
+	s.settings.endHandler = nil
+	s.rewind()
+
+	pos := 0
+	ok := false
+	for s.nextTick() {
+		if stop(pos) {
+			s.tickPending = true
+			ok = true
+			break
+		}
+		s.skipTick()
+		pos += s.framesPerTick
 	}
 
-	return 0, errors.New("unsupported Seek call")
+	s.settings = settings
+	s.bytePos = pos * s.module.frameSize
+	return pos, ok
+}
+
+func (s *Stream) emitSeekSync(t float64, pos int) {
+	if s.settings.eventHandler != nil {
+		s.settings.eventHandler(StreamEvent{
+			Kind:  EventSync,
+			Time:  t,
+			value: math.Float64bits(float64(pos) / s.module.sampleRate),
+		})
+	}
 }
 
 // Read puts next PCM bytes into provided slice.
 //
-// The slice is expected to fit at least a single tick.
+// Read fills b completely unless the song ends, so any slice size works.
+// The audio is mixed tick by tick: if a tick doesn't fit into b,
+// its remaining bytes are kept and returned by the next Read call.
 // With BPM=120, Tempo=10 and SampleRate=44100 a single tick
-// would require 882*bytesPerSample*numChannels = 2208 bytes.
+// would require 882*bytesPerSample*numChannels = 3528 bytes.
 // Note that this library only supports stereo output (numChannels=2)
-// and it produces 16-bit (2 bytes per sample) LE PCM data.
+// and by default it produces 16-bit (2 bytes per sample) LE PCM data
+// (see LoadModuleConfig.SampleFormat).
 // If you need to have precise info, use Stream.GetInfo() method.
+// The slices that fit a whole number of ticks are the most efficient ones,
+// since the tick frames are encoded right into b.
 //
-// If there is a tail in b that was not written to due to the lack
-// of space for a whole tick, n<len(b) will be returned.
-// It doesn't make send to pass a slice that is smaller than a single
-// tick chunk (2k+ bytes), but it makes sense to pass a bigger slice
-// as this method will try to fit as many ticks as possible.
+// Read doesn't allocate memory, so it's safe to call it from a real-time
+// audio callback: the playback never causes the GC pressure.
+// The only exceptions are the note cache filling (see SetNoteCaching) and
+// the control methods that are applied during the Read call (see Stream),
+// like enabling the level metering.
 //
-// When stream has no bytes to produce, io.EOF error is returned.
+// When the song ends, the last bytes are returned along with io.EOF error.
+// All subsequent calls return 0 and io.EOF (unless the stream is rewinded).
+// This makes the stream usable with io.Copy and similar functions.
+// A stream that loops forever never returns io.EOF (see SetLoopCount).
 func (s *Stream) Read(b []byte) (int, error) {
+	s.lock()
+	defer s.unlock()
+
+	if s.finished {
+		return 0, io.EOF
+	}
+
 	written := 0
-	eof := false
+	restarted := false
+
+	for len(b) != 0 {
+		if len(s.carry) != 0 {
+			n := copy(b, s.carry)
+			s.carry = s.carry[n:]
+			s.bytePos += n
+			written += n
+			b = b[n:]
+			continue
+		}
 
-	bytesPerTick := s.module.bytesPerTick
-	for len(b) > bytesPerTick {
-		if !s.nextTick() {
-			eof = true
-			break
+		// A tick boundary: this is where the control methods
+		// that were called during the Read call take effect.
+		s.applyCommands()
+		if len(s.module.patternOrder) == 0 {
+			// The module is unloaded (see Unload): there is nothing
+			// to play or loop, and the end handler is not called.
+			return written, io.EOF
+		}
+
+		// The tick size depends on the current BPM,
+		// so we need to advance the tick state first.
+		eof := s.fading && s.fadeFramesLeft == 0
+		if !eof && !s.tickPending {
+			eof = !s.nextTick()
+			s.tickPending = !eof
+		}
+		if eof {
+			if s.fading {
+				// Make sure that the subsequent calls report the end too.
+				s.fadeFramesLeft = 0
+				s.notifyEnd(false)
+				return written, io.EOF
+			}
+			if restarted {
+				// The restarted song has no ticks to play.
+				return written, nil
+			}
+			if s.settings.loopCount < 0 || s.loopsDone < s.settings.loopCount {
+				s.restart()
+				restarted = true
+				continue
+			}
+			if s.settings.fadeOut != 0 {
+				s.restart()
+				s.fadeOut(s.settings.fadeOut)
+				restarted = true
+				continue
+			}
+			s.notifyEnd(false)
+			return written, io.EOF
+		}
+		restarted = false
+
+		s.mixBuf = s.mixBuffer(s.mixBuf)
+		s.mixTick(s.mixBuf)
+		mix := s.mixBuf[2*s.tickOffset:]
+		s.tickOffset = 0
+		s.tickPending = false
+		if s.fading {
+			s.applyFadeOut(mix)
+		}
+		if s.meter != nil {
+			s.meter.MeasureOutput(mix, s.tickSeconds())
 		}
-		s.readTick(b[:bytesPerTick])
 
-		written += bytesPerTick
-		b = b[bytesPerTick:]
+		n := len(mix) * s.module.format.BytesPerSample()
+		if len(b) < n {
+			// Keep the tick bytes that don't fit for the next iterations
+			// and the next Read calls.
+			if cap(s.tickBytes) < n {
+				s.tickBytes = make([]byte, n)
+			}
+			s.carry = s.tickBytes[:n]
+			encodeFrames(s.carry, mix, s.module.format, s.module.bigEndian)
+			continue
+		}
+		encodeFrames(b[:n], mix, s.module.format, s.module.bigEndian)
+		s.bytePos += n
+		written += n
+		b = b[n:]
 	}
 
-	s.bytePos += written
+	return written, nil
+}
+
+func (s *Stream) notifyEnd(looping bool) {
+	if s.ended {
+		return
+	}
+	s.ended = !looping
+	if s.settings.endHandler != nil {
+		s.settings.endHandler(looping)
+	}
+}
 
-	if eof {
-		if s.settings.loop {
-			s.Rewind()
+// WriteTo implements io.WriterTo.
+//
+// It renders the rest of the song into w tick by tick.
+// The output is identical to what the Read calls would produce
+// until io.EOF, including the looping and the fade-out settings.
+// This makes io.Copy an efficient way to export the whole song.
+//
+// The endless loops (like a Bxx jump to the song start or the WrapAround mode)
+// are cut right before their second iteration, so the rendering always ends.
+// The exception is SetLoopCount(-1): such streams are rendered until w returns an error.
+//
+// After WriteTo returns, the stream is over: Read returns io.EOF until the stream is rewinded.
+func (s *Stream) WriteTo(w io.Writer) (int64, error) {
+	// The stream is not locked during the w.Write calls,
+	// so the other goroutines are not blocked by a slow writer.
+	s.lock()
+	if s.module.frameSize == 0 {
+		s.unlock()
+		return 0, errors.New("no module is loaded")
+	}
+	if s.rowTracker == nil {
+		s.rowTracker = newRowTracker(len(s.module.patternOrder))
+		defer func() {
+			s.lock()
+			s.rowTracker = nil
+			s.unlock()
+		}()
+	}
+	// A buffer of several ticks reduces the number of the w.Write calls.
+	const ticksPerWrite = 16
+	buf := make([]byte, ticksPerWrite*s.framesPerTick*s.module.frameSize)
+	s.unlock()
+
+	written := int64(0)
+	for {
+		n, err := s.Read(buf)
+		if n != 0 {
+			m, err := w.Write(buf[:n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+		}
+		if err == io.EOF {
+			s.lock()
+			s.finished = true
+			s.unlock()
 			return written, nil
 		}
-		return written, io.EOF
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// restart performs a single song loop iteration (see SetLoopCount).
+//
+// If the module has a non-zero restart position, the song start is simulated
+// up to that order entry (like SeekTo does), so the tempo, BPM, global volume
+// and the other effects state are the same as during the first iteration.
+// The playback position (see Seek and Position.Elapsed) is set to the
+// restart position offset, an EventSync is emitted to report it.
+func (s *Stream) restart() {
+	s.notifyEnd(true)
+	loopsDone := s.loopsDone + 1
+	t := s.t
+	pos := 0
+	ok := false
+	if s.module.restartPosition != 0 {
+		// The row tracker makes the simulation stop for the endless loops.
+		tracker := s.rowTracker
+		if tracker == nil {
+			s.rowTracker = newRowTracker(len(s.module.patternOrder))
+		}
+		restartOrder := s.module.restartPosition
+		pos, ok = s.fastForward(func(int) bool {
+			return s.tickIndex == 0 && s.patternIndex == restartOrder && s.patternRowIndex == 0
+		})
+		s.rowTracker = tracker
+		if tracker != nil {
+			tracker.Reset()
+		}
+	}
+	if !ok {
+		s.rewind()
+		pos = 0
+		// Make the nextPattern call select the restart position order entry.
+		s.patternIndex = s.module.restartPosition - 1
+	}
+	s.emitSeekSync(t, pos)
+	s.loopsDone = loopsDone
+}
+
+// applyFadeOut scales the mixed frames by the fade-out volume.
+// The frames after the fade-out end are silenced.
+func (s *Stream) applyFadeOut(mix []float64) {
+	for i := 0; i < len(mix); i += 2 {
+		k := 0.0
+		if s.fadeFramesLeft > 0 {
+			k = float64(s.fadeFramesLeft) / float64(s.fadeFrames)
+			s.fadeFramesLeft--
+		}
+		mix[i] *= k
+		mix[i+1] *= k
 	}
-	return written, nil
 }
 
 // Rewind prepares the stream to play the module right from the start.
 // Doing rewind is relatively cheap.
 func (s *Stream) Rewind() {
+	s.control(s.syncRewind)
+}
+
+// syncRewind is like rewind, but it also emits the EventSync event.
+func (s *Stream) syncRewind() {
 	if s.settings.eventHandler != nil {
 		s.settings.eventHandler(StreamEvent{
 			Kind:  EventSync,
@@ -302,75 +1713,495 @@ func (s *Stream) Rewind() {
 func (s *Stream) rewind() {
 	// Make all fields zero-initialized just to be safe.
 	// Copying the module object is redundant, but oh well (it's a shallow copy anyway).
-	*s = Stream{
+	s.streamState = streamState{
 		module:         s.module,
 		channels:       s.channels,
 		activeChannels: s.activeChannels,
 		settings:       s.settings,
+		rowTracker:     s.rowTracker,
+		noteCache:      s.noteCache,
+		aux:            s.aux,
+		delay:          s.delay,
+		meter:          s.meter,
+		scope:          s.scope,
+
+		// The scratch buffers are kept to avoid the allocations.
+		mixBuf:    s.mixBuf,
+		skipBuf:   s.skipBuf,
+		tickBytes: s.tickBytes,
+		auxMix:    s.auxMix,
+		workers:   s.workers,
+	}
+	if s.aux != nil {
+		s.aux.Reset()
+	}
+	if s.delay != nil {
+		s.delay.Reset()
+	}
+	if s.meter != nil {
+		s.meter.Reset()
+	}
+	if s.scope != nil {
+		s.scope.Reset()
+	}
+	if s.rowTracker != nil {
+		s.rowTracker.Reset()
+	}
+
+	// Now initialize the player to the "ready to start" state.
+	// This code is used as a final part of the constructor as well.
+
+	for i := range s.channels {
+		ch := &s.channels[i]
+		ch.Reset()
+		ch.id = i
+	}
+
+	s.globalVolume = 1.0
+	s.patternIndex = -1
+	s.patternRowsRemain = 0
+	s.patternRowIndex = -1
+	s.rowTicksRemain = 0
+	s.tickIndex = -1
+
+	s.ticksPerRow = s.module.ticksPerRow
+	s.setBPM(s.module.bpm)
+}
+
+// SetBPM changes the playback BPM.
+//
+// Unlike LoadModuleConfig.BPM, it can be used during the playback
+// to speed the music up or slow it down dynamically.
+// The new BPM takes effect from the next tick.
+// Note that the module can change the BPM on its own (see Fxx effect),
+// it will override the value set by this method.
+// Rewind restores the initial BPM.
+//
+// An error is returned if the BPM is too high for the stream sample rate
+// (or if it's zero), the current BPM is not changed in this case.
+func (s *Stream) SetBPM(bpm uint) error {
+	s.lock()
+	defer s.unlock()
+
+	if bpm == 0 {
+		return errors.New("BPM can't be zero")
+	}
+	samplesPerTick, _ := calcSamplesPerTick(s.module.sampleRate, float64(bpm))
+	if samplesPerTick < numRampPoints {
+		return fmt.Errorf("BPM=%v with sample rate=%v results in %v samples per tick (need at least %d)",
+			bpm, s.module.sampleRate, samplesPerTick, numRampPoints)
+	}
+	s.setBPM(float64(bpm))
+	s.reserveTickBuffers(float64(bpm))
+	return nil
+}
+
+// SetTempo changes the playback tempo (the number of ticks per row).
+//
+// Unlike LoadModuleConfig.Tempo, it can be used during the playback.
+// The new tempo takes effect from the next row.
+// Note that the module can change the tempo on its own (see Fxx effect),
+// it will override the value set by this method.
+// Rewind restores the initial tempo.
+//
+// An error is returned if tempo is zero.
+func (s *Stream) SetTempo(tempo uint) error {
+	s.lock()
+	defer s.unlock()
+
+	if tempo == 0 {
+		return errors.New("tempo can't be zero")
+	}
+	s.setTempo(int(tempo))
+	s.reserveTickBuffers(s.bpm)
+	return nil
+}
+
+func (s *Stream) setBPM(bpm float64) {
+	s.bpm = bpm
+	effectiveBPM := s.effectiveBPM()
+	s.samplesPerTick, s.framesPerTick = calcSamplesPerTick(s.module.sampleRate, effectiveBPM)
+	s.secondsPerRow = calcSecondsPerRow(s.ticksPerRow, effectiveBPM)
+}
+
+// effectiveBPM returns the BPM with the time scale applied.
+//
+// The time scale can make the ticks too short to be rendered properly.
+// Such ticks are clamped to numRampPoints frames; the returned BPM
+// is clamped accordingly, so the row durations match the rendered ticks.
+func (s *Stream) effectiveBPM() float64 {
+	bpm := s.bpm * s.settings.timeScale
+	if samplesPerTick, _ := calcSamplesPerTick(s.module.sampleRate, bpm); samplesPerTick < numRampPoints {
+		return s.module.sampleRate / (numRampPoints * 0.4)
+	}
+	return bpm
+}
+
+// reserveTickBuffers preallocates the Read buffers, so the ticks
+// don't need any allocations even if the module slows the tempo down.
+// The buffers are large enough for the slowest BPM of bpm and
+// the minimal BPM that can be set by the Fxx effect.
+// The delay buffer is also large enough for the max tempo of the Fxx effect.
+func (s *Stream) reserveTickBuffers(bpm float64) {
+	const (
+		minEffectBPM   = 32
+		maxEffectTempo = 31
+	)
+	_, numFrames := calcSamplesPerTick(s.module.sampleRate, math.Min(bpm, minEffectBPM)*s.settings.timeScale)
+	if s.delay != nil {
+		ticksPerRow := clampMin(clampMin(s.ticksPerRow, s.module.ticksPerRow), maxEffectTempo)
+		numTicks := s.settings.delay.Rows*ticksPerRow + s.settings.delay.Ticks
+		s.delay.Reserve(numTicks * numFrames)
+	}
+	if cap(s.mixBuf) < 2*numFrames {
+		s.mixBuf = make([]float64, 0, 2*numFrames)
+	}
+	if n := numFrames * s.module.frameSize; cap(s.tickBytes) < n {
+		s.tickBytes = make([]byte, 0, n)
+	}
+	if cap(s.auxMix) < numFrames {
+		s.auxMix = make([]float64, 0, numFrames)
+	}
+	if s.workers != nil {
+		s.workers.Reserve(len(s.channels), numFrames)
+	}
+}
+
+func (s *Stream) setTempo(ticksPerRow int) {
+	s.ticksPerRow = ticksPerRow
+	s.secondsPerRow = calcSecondsPerRow(s.ticksPerRow, s.effectiveBPM())
+}
+
+// SetSampleLoopType overrides the loop type of the loaded instrument sample.
+//
+// This is useful for experimenting: it's possible to hear how
+// a sample would sound with a different loop type without editing the XM file.
+// The loop bounds are taken from the original sample.
+// It's not possible to enable a loop for a sample that has an empty loop.
+//
+// The instrument is a zero-based index, see InstrumentNames.
+// The sample is an instrument sample index; only single-sample
+// instruments are supported right now, so it should be 0.
+//
+// The changes are discarded when a module is loaded again.
+// Note that switching from a ping-pong loop discards the sample data
+// that goes after the loop end.
+func (s *Stream) SetSampleLoopType(instrument, sample int, t xmfile.SampleLoopType) error {
+	s.lock()
+	defer s.unlock()
+
+	if instrument < 0 || instrument >= len(s.module.instruments) {
+		return errors.New("instrument index is out of range")
+	}
+	inst := &s.module.instruments[instrument]
+	if len(inst.samples) == 0 || sample != 0 {
+		return errors.New("sample index is out of range")
+	}
+	if s.noteCache != nil {
+		// The cached notes are rendered from the old samples.
+		for i := range s.channels {
+			if s.channels[i].inst == inst {
+				s.noteCache.Leave(&s.channels[i])
+			}
+		}
+		s.noteCache.Reset()
+	}
+	return inst.setLoopType(t)
+}
+
+// EffectInfo describes a decoded pattern effect.
+type EffectInfo struct {
+	// Name is an effect name, like "PortamentoUp" or "SetVolume".
+	Name string
+
+	// Value is an effect parameter value.
+	// For most effects it matches the raw XM parameter byte,
+	// but for the volume column effects it's already decoded
+	// (e.g. volume byte 0x30 becomes a SetVolume with Value=0x20).
+	Value uint8
+}
+
+// ChannelEffects returns the effects of the current row for the specified channel.
+//
+// This is mostly useful for the debugging and the tracker-like visualizations.
+// If channel index is out of range, nil is returned.
+func (s *Stream) ChannelEffects(channel int) []EffectInfo {
+	s.lock()
+	defer s.unlock()
+
+	return s.channelEffects(channel)
+}
+
+func (s *Stream) channelEffects(channel int) []EffectInfo {
+	if channel < 0 || channel >= len(s.channels) {
+		return nil
+	}
+	ch := &s.channels[channel]
+	if ch.effect.IsEmpty() {
+		return nil
+	}
+	numEffects := ch.effect.Len()
+	offset := ch.effect.Index()
+	effects := s.module.effectTab[offset : offset+numEffects]
+	result := make([]EffectInfo, len(effects))
+	for i, e := range effects {
+		result[i] = EffectInfo{
+			Name:  e.op.String(),
+			Value: e.rawValue,
+		}
+	}
+	return result
+}
+
+// ChannelState describes the current channel playback state.
+// See Stream.ChannelState.
+type ChannelState struct {
+	// Active reports whether the channel produces any sound.
+	// Note that an active channel can still have a zero volume.
+	Active bool
+
+	// KeyOn is false after the note was released (see EventKeyOff).
+	KeyOn bool
+
+	// Note is an XM note value of the last triggered note (1 is C-0, 49 is C-4).
+	// It's 0 if no note was triggered yet.
+	Note int
+
+	// Instrument is a zero-based ID of the current instrument.
+	// It's -1 if there is no instrument.
+	Instrument int
+
+	// Volume is the channel volume in [0, 1] range.
+	// It includes the envelope, fadeout and tremolo,
+	// but not the global volume and the stream settings.
+	Volume float64
+
+	// Panning is the channel panning in [0, 1] range (0.5 is the center).
+	// It includes the panning envelope.
+	Panning float64
+
+	// Period is the current linear period (including the pitch modulations).
+	Period float64
+
+	// Frequency is the current playback frequency (see ChannelFrequencies).
+	Frequency float64
+
+	// Effects are the current row effects (see ChannelEffects).
+	Effects []EffectInfo
+}
+
+// ChannelState returns the current playback state of the specified channel.
+//
+// This is mostly useful for the tracker-like visualizations.
+// The state is updated once per tick.
+func (s *Stream) ChannelState(channel int) (ChannelState, error) {
+	s.lock()
+	defer s.unlock()
+
+	if channel < 0 || channel >= len(s.channels) {
+		return ChannelState{}, errors.New("channel index is out of range")
+	}
+	ch := &s.channels[channel]
+
+	state := ChannelState{
+		Active:     ch.IsActive(),
+		KeyOn:      ch.keyOn,
+		Note:       int(ch.noteValue),
+		Instrument: -1,
+		Effects:    s.channelEffects(channel),
+	}
+	if ch.inst == nil {
+		return state, nil
 	}
 
-	// Now initialize the player to the "ready to start" state.
-	// This code is used as a final part of the constructor as well.
+	state.Instrument = ch.inst.id
+	state.Volume = ch.outputVolume()
+	state.Panning = ch.outputPanning()
+	state.Period = ch.outputPeriod()
+	if state.Active {
+		state.Frequency = (ch.sampleStep / ch.inst.sampleStepMultiplier) * s.module.sampleRate
+	}
+	return state, nil
+}
+
+// ChannelFrequencies returns the current playback frequency (in Hz) of every channel.
+//
+// The frequency is a sample playback rate: a sample that was
+// recorded at this rate would sound at its original pitch.
+// The reported value includes the pitch modulations like
+// arpeggio, vibrato, and portamento effects as well as the SetPitch multiplier.
+// Idle channels report 0.
+//
+// The frequencies are updated once per tick.
+func (s *Stream) ChannelFrequencies() []float64 {
+	s.lock()
+	defer s.unlock()
 
+	result := make([]float64, len(s.channels))
 	for i := range s.channels {
 		ch := &s.channels[i]
-		ch.Reset()
-		ch.id = i
+		if !ch.IsActive() {
+			continue
+		}
+		// The sample step multiplier is an implementation detail
+		// of the sample data layout (see insertSubSamples),
+		// it should not affect the reported frequency.
+		result[i] = (ch.sampleStep / ch.inst.sampleStepMultiplier) * s.module.sampleRate
 	}
+	return result
+}
 
-	s.globalVolume = 1.0
-	s.patternIndex = -1
-	s.patternRowsRemain = 0
-	s.patternRowIndex = -1
-	s.rowTicksRemain = 0
-	s.tickIndex = -1
-
-	s.ticksPerRow = s.module.ticksPerRow
-	s.setBPM(s.module.bpm)
+// InstrumentNames returns the loaded module instrument names.
+// The names are indexed by the zero-based instrument IDs.
+//
+// The names are only available if the module was parsed
+// with xmfile.ParserConfig.NeedStrings option.
+// Otherwise, all names will be empty.
+func (s *Stream) InstrumentNames() []string {
+	s.lock()
+	defer s.unlock()
+
+	names := make([]string, len(s.module.instrumentNames))
+	copy(names, s.module.instrumentNames)
+	return names
 }
 
-func (s *Stream) setBPM(bpm float64) {
-	s.bpm = bpm
-	s.samplesPerTick, s.bytesPerTick = calcSamplesPerTick(s.module.sampleRate, s.bpm)
-	s.secondsPerRow = calcSecondsPerRow(s.module.ticksPerRow, s.bpm)
+// SampleNames returns the sample names of the specified instrument.
+// The instrument is a zero-based index, see InstrumentNames.
+//
+// If instrument index is out of range, nil is returned.
+//
+// Like with InstrumentNames, the names are only available
+// if the module was parsed with xmfile.ParserConfig.NeedStrings option.
+func (s *Stream) SampleNames(instrument int) []string {
+	s.lock()
+	defer s.unlock()
+
+	if instrument < 0 || instrument >= len(s.module.sampleNames) {
+		return nil
+	}
+	names := make([]string, len(s.module.sampleNames[instrument]))
+	copy(names, s.module.sampleNames[instrument])
+	return names
 }
 
 // GetInfo returns stream-related info.
 // See StreamInfo for more details.
 func (s *Stream) GetInfo() StreamInfo {
+	s.lock()
+	defer s.unlock()
+
 	return StreamInfo{
-		BytesPerTick: uint(s.module.bytesPerTick),
+		BytesPerTick: uint(s.module.framesPerTick * s.module.frameSize),
 		MemoryUsage:  moduleSize(&s.module),
 	}
 }
 
+// GetPosition returns the current playback position.
+//
+// The position describes the tick that was processed last.
+// Before the playback starts, the first row position is reported.
+//
+// Note that the position is ahead of the actual audio playback
+// as the PCM bytes are buffered by the audio device.
+func (s *Stream) GetPosition() Position {
+	s.lock()
+	defer s.unlock()
+
+	if len(s.module.patternOrder) == 0 {
+		// No module is loaded.
+		return Position{}
+	}
+	if s.patternIndex < 0 || s.tickIndex < 0 {
+		return Position{
+			Pattern: s.module.patternOrder[0].id,
+		}
+	}
+	frames := s.bytePos / s.module.frameSize
+	return Position{
+		Order:   s.patternIndex,
+		Pattern: s.pattern.id,
+		Row:     s.patternRowIndex,
+		Tick:    s.tickIndex,
+		Elapsed: time.Duration(float64(frames) / s.module.sampleRate * float64(time.Second)),
+	}
+}
+
 func (s *Stream) nextTick() bool {
 	if s.rowTicksRemain == 0 {
 		if !s.nextRow() {
 			return false
 		}
+	} else {
+		s.tickTime += s.tickSeconds()
 	}
 
 	s.rowTicksRemain--
 	s.tickIndex++
+	if s.tickIndex == s.ticksPerRow {
+		// A pattern delay is in progress: the row is repeated,
+		// but its notes are not triggered again.
+		s.tickIndex = 0
+	}
 
-	s.activeChannels = s.activeChannels[:0]
-	baseVolume := s.settings.volumeScaling * s.globalVolume
+	if s.settings.tickHandler != nil && s.settings.tickGranularity == CallbackEveryTick {
+		s.settings.tickHandler(s.patternIndex, s.patternRowIndex, s.tickIndex)
+	}
+
+	// The effects are applied before the output volumes are computed,
+	// so the panning and volume changes are heard on the same tick.
+	// The global volume can be changed by any channel, therefore
+	// all effects should be applied before we compute any output volume.
 	for j := range s.channels {
 		ch := &s.channels[j]
-		note := ch.note
 
 		s.tickEnvelopes(ch)
 
-		panning := ch.panning + (ch.panningEnvelope.value-0.5)*(0.5-abs(ch.panning-0.5))*2
+		if ch.delayedNote != nil {
+			// A delayed note row starts at the delay tick.
+			// All its effects (including the note portamento) start from there too.
+			// If the delay is longer than the row, the note is never played.
+			if s.tickIndex == ch.noteDelayTick {
+				n := ch.delayedNote
+				ch.delayedNote = nil
+				s.triggerChannelRow(ch, n)
+			}
+		} else if !ch.effect.IsEmpty() {
+			s.applyTickEffect(ch)
+		}
+	}
+
+	s.activeChannels = s.activeChannels[:0]
+	baseVolume := s.mixingGain() * s.globalVolume
+	for j := range s.channels {
+		ch := &s.channels[j]
+		note := ch.note
 
-		// 0.25 is an amplification heuristic to avoid clipping.
-		volume := 0.25 * baseVolume * ch.volume * ch.fadeoutVolume * ch.volumeEnvelope.value
-		ch.targetVolume[0] = volume * math.Sqrt(1.0-panning)
-		ch.targetVolume[1] = volume * math.Sqrt(panning)
+		if ch.tremoloRunning && !note.flags.Contains(noteHasTremolo) {
+			ch.tremoloRunning = false
+			ch.tremoloVolumeOffset = 0
+		}
 
-		if !ch.effect.IsEmpty() {
-			s.applyTickEffect(ch)
+		panning := ch.outputPanning()
+		if s.module.amigaPanning {
+			panning = amigaChannelPanning(j)
+		}
+		panning = 0.5 + (panning-0.5)*s.settings.stereoSeparation
+		volume := baseVolume * ch.outputVolume()
+		if j < len(s.settings.channelVolumes) {
+			volume *= s.settings.channelVolumes[j]
+		}
+		if !s.isChannelAudible(j) {
+			volume = 0
+		}
+		leftGain, rightGain := s.settings.panningLaw.panningGains(panning)
+		ch.targetVolume[0] = volume * leftGain
+		ch.targetVolume[1] = volume * rightGain
+		if j < len(s.settings.auxSends) {
+			ch.targetAux = volume * s.settings.auxSends[j]
+		}
+		if j < len(s.settings.channelFilters) {
+			ch.filter.Configure(s.settings.channelFilters[j], s.module.sampleRate)
 		}
 
 		if ch.arpeggioRunning && !note.flags.Contains(noteHasArpeggio) {
@@ -382,10 +2213,20 @@ func (s *Stream) nextTick() bool {
 			ch.vibratoPeriodOffset = 0
 		}
 
-		freq := linearFrequency(ch.period - (64 * ch.arpeggioNoteOffset) - (16 * ch.vibratoPeriodOffset))
-		ch.sampleStep = freq / s.module.sampleRate
+		if ch.glissandoPeriod != 0 && !note.flags.Contains(noteHasNotePortamento) {
+			ch.glissandoPeriod = 0
+		}
+
+		freq := linearFrequency(ch.outputPeriod())
+		sampleStep := freq / s.module.sampleRate * s.settings.pitch
+		if ch.inst != nil {
+			sampleStep *= ch.inst.sampleStepMultiplier
+		}
+		ch.SetSampleStep(sampleStep)
 		if ch.inst != nil {
-			ch.sampleStep *= ch.inst.sampleStepMultiplier
+			if s.noteCache != nil {
+				s.noteCache.Update(ch)
+			}
 		}
 
 		if ch.IsActive() {
@@ -396,6 +2237,14 @@ func (s *Stream) nextTick() bool {
 	return true
 }
 
+func (s *Stream) mixingGain() float64 {
+	if s.settings.referenceMixing {
+		return 1
+	}
+	// 0.25 is an amplification heuristic to avoid clipping.
+	return 0.25 * s.settings.volumeScaling
+}
+
 func (s *Stream) tickEnvelopes(ch *streamChannel) {
 	if ch.inst == nil {
 		return
@@ -440,45 +2289,125 @@ func (s *Stream) envelopeTick(ch *streamChannel, e *envelopeRunner) {
 }
 
 func (s *Stream) nextRow() bool {
+	patternStarted := false
 	if s.jumpKind == jumpNone {
 		// Normal execution.
 		if s.patternRowsRemain == 0 {
 			if !s.nextPattern() {
 				return false
 			}
+			patternStarted = true
 		}
 		s.patternRowIndex++
 		s.patternRowsRemain--
 	} else {
 		// Execute a pattern jump.
+		isLoop := s.jumpKind == jumpPatternLoop
+		if isLoop {
+			// The pattern loop repeats the rows a limited number of times.
+			// The repeated rows should not be treated as an endless loop.
+			if s.rowTracker != nil {
+				s.rowTracker.Forget(s.patternIndex, s.jumpRow, s.patternRowIndex)
+			}
+		}
 		s.jumpKind = jumpNone
+		if s.jumpPattern >= len(s.module.patternOrder) {
+			// Jumping past the last order entry is like reaching the song end.
+			if !s.module.wrapAround {
+				// Make sure that the next nextRow call reports the end too.
+				s.patternIndex = len(s.module.patternOrder) - 1
+				s.patternRowsRemain = 0
+				return false
+			}
+			s.jumpPattern = 0
+			s.notifyEnd(true)
+		}
 		s.selectPattern(s.jumpPattern)
+		if s.jumpRow >= s.pattern.numRows {
+			// FastTracker II starts the pattern from the beginning
+			// if the target row is out of its bounds.
+			s.jumpRow = 0
+		}
 		s.patternRowIndex = s.jumpRow
 		s.patternRowsRemain = s.pattern.numRows - s.patternRowIndex - 1
+		// A pattern loop is a jump inside the same pattern.
+		patternStarted = !isLoop
+	}
+
+	if s.rowTracker != nil && !s.rowTracker.Visit(s.patternIndex, s.patternRowIndex) {
+		// This row was already played: the song is looping.
+		return false
 	}
 
 	noteOffset := s.pattern.numChannels * s.patternRowIndex
 	notes := s.pattern.notes[noteOffset : noteOffset+s.pattern.numChannels]
 	m := &s.module
 
+	s.patternDelay = 0
+	s.tickTime = s.t
 	for i := range s.channels {
 		s.advanceChannelRow(&s.channels[i], &m.noteTab[notes[i]])
 	}
 
-	s.t += s.module.secondsPerRow
-	s.rowTicksRemain = s.ticksPerRow
+	numRows := 1 + s.patternDelay
+	s.t += s.secondsPerRow * float64(numRows)
+	s.rowTicksRemain = s.ticksPerRow * numRows
 	s.tickIndex = -1
+
+	if patternStarted && s.settings.patternHandler != nil {
+		s.settings.patternHandler(s.patternIndex, s.pattern.id)
+	}
+	if s.settings.rowHandler != nil {
+		s.settings.rowHandler(s.patternIndex, s.pattern.id, s.patternRowIndex)
+	}
+	if s.settings.tickHandler != nil {
+		switch s.settings.tickGranularity {
+		case CallbackEveryRow:
+			s.settings.tickHandler(s.patternIndex, s.patternRowIndex, 0)
+		case CallbackEveryPattern:
+			if patternStarted {
+				s.settings.tickHandler(s.patternIndex, s.patternRowIndex, 0)
+			}
+		}
+	}
+
 	return true
 }
 
 func (s *Stream) advanceChannelRow(ch *streamChannel, n *patternNote) {
+	ch.delayedNote = nil
+	if n.flags.Contains(noteHasNoteDelay) {
+		// The note and its row effects are applied later (see nextTick).
+		// Until then, the channel keeps playing its current note.
+		ch.note = n
+		ch.effect = n.effect
+		ch.delayedNote = n
+		ch.noteDelayTick = s.noteDelayTick(n)
+		return
+	}
+	s.triggerChannelRow(ch, n)
+}
+
+func (s *Stream) noteDelayTick(n *patternNote) int {
+	numEffects := n.effect.Len()
+	offset := n.effect.Index()
+	for _, e := range s.module.effectTab[offset : offset+numEffects] {
+		if e.op == xmdb.EffectNoteDelay {
+			return int(e.arp[0])
+		}
+	}
+	return 0
+}
+
+func (s *Stream) triggerChannelRow(ch *streamChannel, n *patternNote) {
 	ch.assignNote(n)
 
 	if !ch.effect.IsEmpty() {
 		s.applyRowEffect(ch, n)
 	}
 
-	if s.settings.eventHandler != nil && n.raw != 0 {
+	// The key-off notes are reported via EventKeyOff (see keyOff).
+	if s.settings.eventHandler != nil && n.raw != 0 && n.raw != 97 {
 		instID := 255 // It's a sentinel value that fits 8 bits
 		if ch.inst != nil {
 			instID = ch.inst.id
@@ -487,7 +2416,7 @@ func (s *Stream) advanceChannelRow(ch *streamChannel, n *patternNote) {
 		s.settings.eventHandler(StreamEvent{
 			Kind:    EventNote,
 			Channel: ch.id,
-			Time:    s.t,
+			Time:    s.tickTime,
 			value:   value,
 		})
 	}
@@ -509,6 +2438,12 @@ func (s *Stream) applyRowEffect(ch *streamChannel, n *patternNote) {
 				ch.volumeSlideValue = e.floatValue
 			}
 
+		case xmdb.EffectNotePortamentoWithVolumeSlide:
+			if e.floatValue != 0 {
+				ch.volumeSlideValue = e.floatValue
+			}
+			s.setNotePortamentoTarget(ch, n)
+
 		case xmdb.EffectGlobalVolumeSlide:
 			if e.floatValue != 0 {
 				ch.globalVolumeSlideValue = e.floatValue
@@ -529,43 +2464,165 @@ func (s *Stream) applyRowEffect(ch *streamChannel, n *patternNote) {
 				ch.portamentoDownValue = e.floatValue
 			}
 
-		case xmdb.EffectNotePortamento:
-			if n.raw == 0 {
+		case xmdb.EffectFinePortamentoUp:
+			// Unlike the normal portamento, it's applied once per row.
+			if e.floatValue != 0 {
+				ch.finePortamentoUpValue = e.floatValue
+			}
+			ch.period = clampMin(ch.period-ch.finePortamentoUpValue, minPeriod)
+
+		case xmdb.EffectFinePortamentoDown:
+			// The memory is not shared with E1x.
+			if e.floatValue != 0 {
+				ch.finePortamentoDownValue = e.floatValue
+			}
+			ch.period = clampMax(ch.period+ch.finePortamentoDownValue, maxPeriod)
+
+		case xmdb.EffectExtraFinePortamentoUp:
+			if e.floatValue != 0 {
+				ch.extraFinePortamentoUpValue = e.floatValue
+			}
+			ch.period = clampMin(ch.period-ch.extraFinePortamentoUpValue, minPeriod)
+		case xmdb.EffectExtraFinePortamentoDown:
+			if e.floatValue != 0 {
+				ch.extraFinePortamentoDownValue = e.floatValue
+			}
+			ch.period = clampMax(ch.period+ch.extraFinePortamentoDownValue, maxPeriod)
+
+		case xmdb.EffectGlissandoControl:
+			ch.glissando = e.arp[0] != 0
+
+		case xmdb.EffectSetEnvelopePosition:
+			if ch.inst == nil {
+				break
+			}
+			ch.volumeEnvelope.frame = int(e.rawValue)
+			// FastTracker II only changes the panning envelope position
+			// if the volume envelope has the sustain flag set.
+			// Some tracks may depend on that quirk, so we follow it.
+			if ch.volumeEnvelope.flags.SustainEnabled() {
+				ch.panningEnvelope.frame = int(e.rawValue)
+			}
+
+		case xmdb.EffectSetFinetune:
+			// The finetune override only affects the note of this row.
+			if !n.flags.Contains(noteValid) || ch.inst == nil {
+				break
+			}
+			if n.flags.Contains(noteHasNotePortamento) {
 				break
 			}
+			period, ok := calcNotePeriod(n.raw, ch.inst)
+			if !ok {
+				break
+			}
+			// Replace the sample finetune with the new one.
+			// Every finetune unit is 1/128 of a semitone (64 period units).
+			ch.period = period + (float64(ch.inst.finetune)-e.floatValue)/2
+
+		case xmdb.EffectSetVibratoControl:
+			ch.vibratoWaveform = waveformKind(e.arp[0] & 0b11)
+			ch.vibratoNoRetrigger = e.arp[0]&0b100 != 0
+
+		case xmdb.EffectSetVibratoSpeed:
+			// Unlike the vibrato effect, it can set the speed to 0.
+			ch.vibratoSpeed = e.arp[0]
+
+		case xmdb.EffectSetTremoloControl:
+			ch.tremoloWaveform = waveformKind(e.arp[0] & 0b11)
+			ch.tremoloNoRetrigger = e.arp[0]&0b100 != 0
+
+		case xmdb.EffectTremolo:
+			if e.arp[0] != 0 {
+				ch.tremoloSpeed = e.arp[0]
+			}
+			if e.arp[1] != 0 {
+				ch.tremoloDepth = e.arp[1]
+			}
+
+		case xmdb.EffectNotePortamento:
 			if e.floatValue != 0 {
 				ch.notePortamentoValue = e.floatValue
 			}
-			// TODO: can we precalculate this period in the compiler, somehow?
-			ch.notePortamentoTargetPeriod = linearPeriod(calcRealNote(n.raw, ch.inst))
+			s.setNotePortamentoTarget(ch, n)
 
 		case xmdb.EffectVibrato:
 			if e.arp[0] != 0 {
 				ch.vibratoSpeed = e.arp[0]
 			}
-			if e.floatValue != 0 {
-				ch.vibratoDepth = e.floatValue
+			if e.arp[1] != 0 {
+				ch.vibratoDepth = e.arp[1]
 			}
 
 		case xmdb.EffectPatternBreak:
-			s.jumpKind = jumpPatternBreak
-			s.jumpPattern = s.patternIndex + 1
+			// When combined with a position jump that was executed before
+			// (on the same row), only the target row is changed.
+			// This is how Bxx+Dxx combos work in FastTracker II.
+			if s.jumpKind != jumpPositionJump {
+				s.jumpKind = jumpPatternBreak
+				s.jumpPattern = s.patternIndex + 1
+			}
 			s.jumpRow = int(e.arp[0])
 
+		case xmdb.EffectPatternLoop:
+			if e.arp[0] == 0 {
+				ch.patternLoopRow = s.patternRowIndex
+				break
+			}
+			if ch.patternLoopCounter == 0 {
+				ch.patternLoopCounter = int(e.arp[0])
+			} else {
+				ch.patternLoopCounter--
+				if ch.patternLoopCounter == 0 {
+					// The loop is over.
+					break
+				}
+			}
+			// The pattern break and position jump effects have a priority.
+			if s.jumpKind == jumpNone {
+				s.jumpKind = jumpPatternLoop
+				s.jumpPattern = s.patternIndex
+				s.jumpRow = ch.patternLoopRow
+			}
+
+		case xmdb.EffectPositionJump:
+			// A position jump resets the target row even if
+			// there was a pattern break before it.
+			s.jumpKind = jumpPositionJump
+			s.jumpPattern = int(e.rawValue)
+			s.jumpRow = 0
+
 		case xmdb.EffectSetBPM:
 			s.setBPM(e.floatValue)
 
 		case xmdb.EffectSetTempo:
-			s.ticksPerRow = int(e.rawValue)
+			s.setTempo(int(e.rawValue))
 
 		case xmdb.EffectFineVolumeSlideDown:
 			ch.volume = clampMin(ch.volume-e.floatValue, 0)
 		case xmdb.EffectFineVolumeSlideUp:
 			ch.volume = clampMax(ch.volume+e.floatValue, 1)
 
+		case xmdb.EffectExtFineVolumeSlideUp:
+			if e.floatValue != 0 {
+				ch.fineVolumeSlideUpValue = e.floatValue
+			}
+			ch.volume = clampMax(ch.volume+ch.fineVolumeSlideUpValue, 1)
+		case xmdb.EffectExtFineVolumeSlideDown:
+			if e.floatValue != 0 {
+				ch.fineVolumeSlideDownValue = e.floatValue
+			}
+			ch.volume = clampMin(ch.volume-ch.fineVolumeSlideDownValue, 0)
+
 		case xmdb.EffectSetGlobalVolume:
 			s.globalVolume = e.floatValue
 
+		case xmdb.EffectPatternDelay:
+			// If there are several delays on the same row, the first one wins.
+			if s.patternDelay == 0 {
+				s.patternDelay = int(e.arp[0])
+			}
+
 		case xmdb.EffectSetPanning:
 			ch.panning = e.floatValue
 
@@ -578,30 +2635,122 @@ func (s *Stream) applyRowEffect(ch *streamChannel, n *patternNote) {
 			// inferred by the compiler, but it won't work in case of a
 			// pattern jump, etc.)
 			// Since this is not a hot path, let's compute the offset the hard way.
-			offset := 0.0
-			if ch.inst.sample16bit {
-				offset = e.floatValue * 0.5
-			} else {
-				offset = e.floatValue
-			}
+			// Like in FastTracker II, the offset is specified in sample frames
+			// for both 8-bit and 16-bit samples.
+			offset := e.floatValue
 			if ch.inst.numSubSamples != 0 {
 				offset = float64(int(offset) * (ch.inst.numSubSamples + 1))
 			}
-			ch.sampleOffset = offset
+			ch.SetSampleOffset(offset)
 		}
 	}
 }
 
+func (s *Stream) setNotePortamentoTarget(ch *streamChannel, n *patternNote) {
+	// A row without a note (or with a key-off) keeps sliding
+	// towards the previous target. The note itself is not
+	// triggered (see assignNote), it only sets a new target.
+	if !n.flags.Contains(noteValid) {
+		return
+	}
+	// TODO: can we precalculate this period in the compiler, somehow?
+	targetPeriod, ok := calcNotePeriod(n.raw, ch.inst)
+	if !ok {
+		return
+	}
+	ch.notePortamentoTargetPeriod = targetPeriod
+}
+
+func (s *Stream) notePortamento(ch *streamChannel) {
+	if ch.notePortamentoTargetPeriod == 0 {
+		return
+	}
+	if ch.period == ch.notePortamentoTargetPeriod {
+		return
+	}
+	ch.period = slideTowards(ch.period, ch.notePortamentoTargetPeriod, ch.notePortamentoValue)
+	if ch.glissando {
+		// The period itself is still sliding smoothly,
+		// but the played note is rounded to the nearest semitone.
+		ch.glissandoPeriod = glissandoPeriod(ch.period, ch.inst)
+	}
+}
+
 func (s *Stream) keyOff(ch *streamChannel) {
 	ch.keyOn = false
 	if ch.inst == nil || !ch.volumeEnvelope.flags.IsOn() {
 		ch.volume = 0
 	}
+
+	if s.settings.eventHandler != nil {
+		instID := 255 // It's a sentinel value that fits 8 bits
+		if ch.inst != nil {
+			instID = ch.inst.id
+		}
+		s.settings.eventHandler(StreamEvent{
+			Kind:    EventKeyOff,
+			Channel: ch.id,
+			Time:    s.tickTime,
+			value:   uint64(ch.noteValue) | uint64(instID<<8),
+		})
+	}
 }
 
 func (s *Stream) vibrato(ch *streamChannel) {
-	ch.vibratoStep += ch.vibratoSpeed
-	ch.vibratoPeriodOffset = -2 * waveform(ch.vibratoStep) * ch.vibratoDepth
+	// This is how FastTracker II computes the vibrato:
+	// the waveform value is scaled by the depth and
+	// then applied to the period for the current tick only.
+	// The division truncates towards zero, so the negative
+	// values are rounded the same way as the positive ones.
+	v := waveform(ch.vibratoWaveform, ch.vibratoPos, &ch.rng)
+	ch.vibratoPeriodOffset = float64(v * int(ch.vibratoDepth) / 32)
+	ch.vibratoPos += ch.vibratoSpeed
+}
+
+func (s *Stream) tremolo(ch *streamChannel) {
+	// Like vibrato, but it modulates the volume instead of the period.
+	// The volume units are 1/64.
+	v := waveform(ch.tremoloWaveform, ch.tremoloPos, &ch.rng)
+	ch.tremoloVolumeOffset = float64(v*int(ch.tremoloDepth)/64) / 64
+	ch.tremoloPos += ch.tremoloSpeed
+}
+
+func (s *Stream) multiRetrigger(ch *streamChannel) {
+	ch.multiRetriggerCounter++
+	if ch.multiRetriggerCounter < ch.multiRetriggerInterval {
+		return
+	}
+	ch.multiRetriggerCounter = 0
+
+	// This is a FastTracker II volume modification table.
+	// The volume is in [0, 64] range here.
+	vol := int(math.Round(ch.volume * 64))
+	switch ch.multiRetriggerVolume {
+	case 0x1, 0x2, 0x3, 0x4, 0x5:
+		vol -= 1 << (ch.multiRetriggerVolume - 1)
+	case 0x6:
+		vol = (vol >> 1) + (vol >> 3) + (vol >> 4)
+	case 0x7:
+		vol >>= 1
+	case 0x9, 0xA, 0xB, 0xC, 0xD:
+		vol += 1 << (ch.multiRetriggerVolume - 0x9)
+	case 0xE:
+		vol = (vol >> 1) + vol
+	case 0xF:
+		vol += vol
+	}
+	ch.volume = xmVolume(vol)
+
+	// The volume column "set volume" is re-applied on every retrigger.
+	numEffects := ch.effect.Len()
+	offset := ch.effect.Index()
+	for _, e := range s.module.effectTab[offset : offset+numEffects] {
+		if e.op == xmdb.EffectSetVolume {
+			ch.volume = e.floatValue
+		}
+	}
+
+	ch.retrigger()
 }
 
 func (s *Stream) applyTickEffect(ch *streamChannel) {
@@ -614,26 +2763,29 @@ func (s *Stream) applyTickEffect(ch *streamChannel) {
 			if s.tickIndex == 0 {
 				break
 			}
-			// XM_MINPERIOD is defined as 50 in MilkyTracker.
-			ch.period = clampMin(ch.period-ch.portamentoUpValue, 50)
+			// FastTracker II slides the period down to 1 (the highest pitch).
+			// MilkyTracker uses 50 instead, but we follow FT2 here.
+			ch.period = clampMin(ch.period-ch.portamentoUpValue, minPeriod)
 
 		case xmdb.EffectPortamentoDown:
 			if s.tickIndex == 0 {
 				break
 			}
-			ch.period += ch.portamentoDownValue
+			// FastTracker II stops the slide at 31999 (the lowest pitch).
+			ch.period = clampMax(ch.period+ch.portamentoDownValue, maxPeriod)
 
 		case xmdb.EffectNotePortamento:
 			if s.tickIndex == 0 {
 				break
 			}
-			if ch.notePortamentoTargetPeriod == 0 {
-				break
-			}
-			if ch.period == ch.notePortamentoTargetPeriod {
+			s.notePortamento(ch)
+
+		case xmdb.EffectNotePortamentoWithVolumeSlide:
+			if s.tickIndex == 0 {
 				break
 			}
-			ch.period = slideTowards(ch.period, ch.notePortamentoTargetPeriod, ch.notePortamentoValue)
+			s.notePortamento(ch)
+			ch.volume = clamp(ch.volume+ch.volumeSlideValue, 0, 1)
 
 		case xmdb.EffectVibrato:
 			if s.tickIndex == 0 {
@@ -642,6 +2794,31 @@ func (s *Stream) applyTickEffect(ch *streamChannel) {
 			ch.vibratoRunning = true
 			s.vibrato(ch)
 
+		case xmdb.EffectTremolo:
+			if s.tickIndex == 0 {
+				break
+			}
+			ch.tremoloRunning = true
+			s.tremolo(ch)
+
+		case xmdb.EffectRetrigger:
+			if s.tickIndex == 0 || s.tickIndex%int(e.arp[0]) != 0 {
+				break
+			}
+			ch.retrigger()
+
+		case xmdb.EffectMultiRetrigger:
+			if e.arp[0] != 0 {
+				ch.multiRetriggerInterval = e.arp[0]
+			}
+			if e.arp[1] != 0 {
+				ch.multiRetriggerVolume = e.arp[1]
+			}
+			if s.tickIndex == 0 {
+				break
+			}
+			s.multiRetrigger(ch)
+
 		case xmdb.EffectKeyOff:
 			if e.rawValue != uint8(s.tickIndex) {
 				break
@@ -686,13 +2863,27 @@ func (s *Stream) applyTickEffect(ch *streamChannel) {
 			ch.volume = clamp(ch.volume+ch.volumeSlideValue, 0, 1)
 
 		case xmdb.EffectVolumeSlideDown:
+			// Like all normal slides, the volume column slides
+			// are not applied on the first tick of the row.
+			if s.tickIndex == 0 {
+				break
+			}
 			ch.volume = clampMin(ch.volume-e.floatValue, 0)
 		case xmdb.EffectVolumeSlideUp:
+			if s.tickIndex == 0 {
+				break
+			}
 			ch.volume = clampMax(ch.volume+e.floatValue, 1)
 
 		case xmdb.EffectPanningSlideLeft:
+			if s.tickIndex == 0 {
+				break
+			}
 			ch.panning = clampMin(ch.panning-e.floatValue, 0)
 		case xmdb.EffectPanningSlideRight:
+			if s.tickIndex == 0 {
+				break
+			}
 			ch.panning = clampMax(ch.panning+e.floatValue, 1)
 		}
 	}
@@ -701,7 +2892,11 @@ func (s *Stream) applyTickEffect(ch *streamChannel) {
 func (s *Stream) nextPattern() bool {
 	i := s.patternIndex + 1
 	if i >= len(s.module.patternOrder) {
-		return false
+		if !s.module.wrapAround {
+			return false
+		}
+		i = 0
+		s.notifyEnd(true)
 	}
 	s.selectPattern(i)
 	return true
@@ -715,48 +2910,164 @@ func (s *Stream) selectPattern(i int) {
 	s.patternRowsRemain = s.pattern.numRows
 }
 
-func (s *Stream) readTick(b []byte) {
+// skipTick advances the channels like mixTick does, but without the actual mixing.
+func (s *Stream) skipTick() {
+	volumeRamp := s.module.volumeRampStep
+
+	if s.aux != nil || s.delay != nil || s.settings.numFilters != 0 {
+		// The aux bus, the delay and the channel filters keep the signal history
+		// (like the echo tail), so they need to be fed even if the output is discarded.
+		s.skipBuf = s.mixBuffer(s.skipBuf)
+		s.mixTick(s.skipBuf)
+		return
+	}
+
+	numFrames := s.framesPerTick
+	for _, ch := range s.activeChannels {
+		for i := 0; i < numRampPoints; i++ {
+			ch.NextSample()
+			ch.rampFrame++
+			ch.slideVolumes(volumeRamp)
+		}
+		for i := numRampPoints; i < numFrames; i++ {
+			ch.NextSample()
+			if ch.IsRamping() {
+				ch.slideVolumes(volumeRamp)
+			}
+		}
+	}
+}
+
+// mixTick mixes the current tick channels into the stereo frames.
+// The mix has interleaved left and right samples, its length is 2*framesPerTick.
+// The mixed values are not clamped; they use the 16-bit PCM scale.
+func (s *Stream) mixTick(mix []float64) {
 	// This function dominates the music rendering execution time.
 	// It's important to keep it very efficient.
 	// The slightest change inside this nested loop can result in ~10% playback
 	// performance regression.
 
-	n := s.module.bytesPerTick
+	n := len(mix)
 
-	const (
-		rampBytes  = 2 * 2 * numRampPoints
-		volumeRamp = 1.0 / 180.0
-	)
+	const rampLen = 2 * numRampPoints
+	volumeRamp := s.module.volumeRampStep
+
+	// The channels are mixed in float64 and then converted to the output format.
+	// Mixing directly into int16 would make the loud parts wrap around.
 
-	for i := 0; i < rampBytes; i += 4 {
-		left := int16(0)
-		right := int16(0)
+	meter := s.meter
+	scope := s.scope
+	if scope != nil {
+		scope.BeginTick(n / 2)
+	}
+
+	for i := 0; i < rampLen; i += 2 {
+		left := 0.0
+		right := 0.0
+		aux := 0.0
 
 		for _, ch := range s.activeChannels {
 			v := float64(ch.NextSample())
 			if ch.rampFrame < uint(len(ch.rampSamples)) {
 				v = lerp(ch.rampSamples[ch.rampFrame], v, float64(ch.rampFrame)/float64(len(ch.rampSamples)))
 			}
-			left += int16(v * ch.computedVolume[0])
-			right += int16(v * ch.computedVolume[1])
+			if ch.filter.IsActive() {
+				v = ch.filter.Next(v)
+			}
+			l := v * ch.computedVolume[0]
+			r := v * ch.computedVolume[1]
+			left += l
+			right += r
+			aux += v * ch.auxVolume
+			if meter != nil {
+				meter.channels[ch.id].Add(math.Max(abs(l), abs(r)))
+			}
+			if scope != nil {
+				scope.Put(ch.id, (l+r)*0.5)
+			}
 			ch.rampFrame++
-			ch.computedVolume[0] = slideTowards(ch.computedVolume[0], ch.targetVolume[0], volumeRamp)
-			ch.computedVolume[1] = slideTowards(ch.computedVolume[1], ch.targetVolume[1], volumeRamp)
+			ch.slideVolumes(volumeRamp)
+		}
+		if scope != nil {
+			scope.NextFrame()
+		}
+
+		if s.aux != nil {
+			wetLeft, wetRight := s.aux.Process(aux)
+			left += wetLeft
+			right += wetRight
 		}
 
-		putPCM(b[i:], uint16(left), uint16(right))
+		mix[i] = left
+		mix[i+1] = right
 	}
 
-	for i := rampBytes; i < n; i += 4 {
-		left := int16(0)
-		right := int16(0)
+	if meter != nil || scope != nil {
+		s.mixInstrumented(mix[rampLen:])
+	} else {
+		s.mixChannels(mix[rampLen:])
+	}
+	if meter != nil {
+		// The ramp frames are measured too, so the whole tick is used.
+		meter.FinishChannels(n/2, s.tickSeconds())
+	}
+
+	if s.delay != nil {
+		s.delay.Sync(s.ticksPerRow, s.samplesPerTick)
+		s.delay.Process(mix)
+	}
+
+	if !s.settings.referenceMixing {
+		softClip(mix)
+	}
+}
+
+// mixInstrumented is a mixTick mixing loop that also collects
+// the channel levels and the scope frames.
+// The scope frames for the current tick should be already prepared (see channelScope.BeginTick),
+// the channel levels are finished by the caller (see levelMeter.FinishChannels).
+// It's a separate function to keep the mixTick loop as fast as possible.
+func (s *Stream) mixInstrumented(mix []float64) {
+	meter := s.meter
+	scope := s.scope
+	volumeRamp := s.module.volumeRampStep
+
+	for i := 0; i < len(mix); i += 2 {
+		left := 0.0
+		right := 0.0
+		aux := 0.0
 
 		for _, ch := range s.activeChannels {
 			v := float64(ch.NextSample())
-			left += int16(v * ch.computedVolume[0])
-			right += int16(v * ch.computedVolume[1])
+			if ch.filter.IsActive() {
+				v = ch.filter.Next(v)
+			}
+			l := v * ch.computedVolume[0]
+			r := v * ch.computedVolume[1]
+			left += l
+			right += r
+			aux += v * ch.auxVolume
+			if ch.IsRamping() {
+				ch.slideVolumes(volumeRamp)
+			}
+			if meter != nil {
+				meter.channels[ch.id].Add(math.Max(abs(l), abs(r)))
+			}
+			if scope != nil {
+				scope.Put(ch.id, (l+r)*0.5)
+			}
+		}
+		if scope != nil {
+			scope.NextFrame()
+		}
+
+		if s.aux != nil {
+			wetLeft, wetRight := s.aux.Process(aux)
+			left += wetLeft
+			right += wetRight
 		}
 
-		putPCM(b[i:], uint16(left), uint16(right))
+		mix[i] = left
+		mix[i+1] = right
 	}
 }
@@ -0,0 +1,170 @@
+package xm
+
+import (
+	"time"
+)
+
+// PatternDurations returns the play time of every pattern order entry.
+//
+// The result slice is indexed by the pattern order index,
+// so its length matches the song length.
+// The durations are calculated by simulating the playback from the start
+// using the stream BPM and tempo settings; this means that all effects
+// that affect the timings (set speed, pattern breaks, pattern delays)
+// are taken into account. A pattern that was cut by a pattern break
+// will have a shorter duration than it would have otherwise.
+//
+// An order entry that is never reached during the playback
+// will have a zero duration.
+// If some order entry is played several times, its duration
+// is a sum of all its playbacks.
+// If the song contains an endless loop (see HasEndlessLoop),
+// the simulation stops right before the looping point.
+//
+// This method does not affect the stream playback state.
+func (s *Stream) PatternDurations() []time.Duration {
+	s.lock()
+	defer s.unlock()
+
+	seconds := make([]float64, len(s.module.patternOrder))
+
+	sim := s.cloneForAnalysis()
+	for sim.nextTick() {
+		seconds[sim.patternIndex] += sim.tickSeconds()
+	}
+
+	durations := make([]time.Duration, len(seconds))
+	for i, sec := range seconds {
+		durations[i] = time.Duration(sec * float64(time.Second))
+	}
+	return durations
+}
+
+// Duration returns the total play time of the song.
+//
+// The song playback is simulated from the start, so all jumps, pattern loops
+// and tempo changes are taken into account.
+// The result matches the length of the audio that Read produces
+// before it returns io.EOF (the looping settings are ignored).
+// If the song contains an endless loop (see HasEndlessLoop),
+// the simulation stops right before the looping point.
+//
+// This method does not affect the stream playback state.
+func (s *Stream) Duration() time.Duration {
+	s.lock()
+	defer s.unlock()
+
+	return time.Duration(float64(s.songFrames()) / s.module.sampleRate * float64(time.Second))
+}
+
+// songFrames returns the song length in output frames (see Duration).
+func (s *Stream) songFrames() int {
+	numFrames := 0
+	sim := s.cloneForAnalysis()
+	for sim.nextTick() {
+		numFrames += sim.framesPerTick
+	}
+	return numFrames
+}
+
+// HasEndlessLoop reports whether the song contains a jump that makes it loop forever.
+//
+// Modules with such loops never end on their own: Read will never return io.EOF.
+// This is not necessarily an error, a lot of game music is created this way.
+//
+// This method does not affect the stream playback state.
+func (s *Stream) HasEndlessLoop() bool {
+	s.lock()
+	defer s.unlock()
+
+	sim := s.cloneForAnalysis()
+	for sim.nextTick() {
+	}
+	return sim.rowTracker.looped
+}
+
+// cloneForAnalysis returns a stream copy that can be used to simulate
+// the playback without affecting the original stream.
+//
+// The clone is rewinded to the song start.
+// It has no event handlers and it never loops.
+// If the song has an endless loop, the clone playback
+// ends right before the second iteration of that loop.
+func (s *Stream) cloneForAnalysis() *Stream {
+	clone := &Stream{
+		streamState: streamState{
+			module:         s.module,
+			channels:       make([]streamChannel, len(s.channels)),
+			activeChannels: make([]*streamChannel, 0, len(s.channels)),
+			settings:       s.settings,
+			rowTracker:     newRowTracker(len(s.module.patternOrder)),
+		},
+	}
+	clone.settings.loopCount = 0
+	clone.settings.eventHandler = nil
+	clone.settings.tickHandler = nil
+	clone.settings.rowHandler = nil
+	clone.settings.patternHandler = nil
+	clone.settings.endHandler = nil
+	if s.aux != nil {
+		clone.aux = clone.newAuxBus()
+	}
+	if s.delay != nil {
+		clone.delay = newMasterDelay(s.settings.delay)
+	}
+	clone.rewind()
+	if clone.delay != nil {
+		clone.reserveTickBuffers(clone.bpm)
+	}
+	return clone
+}
+
+func (s *Stream) tickSeconds() float64 {
+	return s.samplesPerTick / s.module.sampleRate
+}
+
+// rowTracker records the visited pattern rows.
+// It's used to detect the endless loops during the song analysis.
+type rowTracker struct {
+	// visited is a bitset of (order, row) pairs.
+	// Every order entry has 256 bits (the max number of rows).
+	visited []uint64
+
+	looped bool
+}
+
+func newRowTracker(numOrders int) *rowTracker {
+	return &rowTracker{
+		visited: make([]uint64, numOrders*(256/64)),
+	}
+}
+
+func (t *rowTracker) Reset() {
+	for i := range t.visited {
+		t.visited[i] = 0
+	}
+	t.looped = false
+}
+
+// Forget marks the rows in [fromRow, toRow] range as not visited.
+// It's used for the pattern loops: they repeat the rows a finite
+// number of times, so it's not an endless loop.
+func (t *rowTracker) Forget(order, fromRow, toRow int) {
+	for row := fromRow; row <= toRow; row++ {
+		i := order*256 + row
+		t.visited[i/64] &^= uint64(1) << (i % 64)
+	}
+}
+
+// Visit marks the row as visited.
+// It returns false if that row was already visited before.
+func (t *rowTracker) Visit(order, row int) bool {
+	i := order*256 + row
+	mask := uint64(1) << (i % 64)
+	if t.visited[i/64]&mask != 0 {
+		t.looped = true
+		return false
+	}
+	t.visited[i/64] |= mask
+	return true
+}
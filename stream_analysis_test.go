@@ -0,0 +1,152 @@
+package xm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPatternDurations(t *testing.T) {
+	const rowDuration = 120 * time.Millisecond // Tempo=6, BPM=125
+
+	song := newTestSong(1, 16, 16, 16)
+	song.patterns[1][5][0] = fx(0x0D, 0x02) // Break to the row 2 of the next pattern
+	song.patterns[2][3][0] = fx(0x0E, 0xE1) // Pattern delay: the row is played twice
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	have := s.PatternDurations()
+	want := []time.Duration{
+		16 * rowDuration,
+		6 * rowDuration,
+		(14 + 1) * rowDuration,
+	}
+	if len(have) != len(want) {
+		t.Fatalf("got %d durations, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i].Round(time.Millisecond) != want[i] {
+			t.Errorf("pattern[%d] duration is %v, want %v", i, have[i], want[i])
+		}
+	}
+	if have[1] >= have[0] {
+		t.Errorf("the pattern with a break should be shorter than a full pattern")
+	}
+}
+
+func TestHasEndlessLoop(t *testing.T) {
+	const rowDuration = 120 * time.Millisecond // Tempo=6, BPM=125
+
+	tests := []struct {
+		name     string
+		song     func() *testSong
+		endless  bool
+		duration time.Duration
+	}{
+		{
+			name: "no jumps",
+			song: func() *testSong {
+				return newTestSong(1, 4, 4)
+			},
+			duration: 8 * rowDuration,
+		},
+		{
+			name: "pattern break",
+			song: func() *testSong {
+				song := newTestSong(1, 4, 4)
+				song.patterns[0][1][0] = fx(0x0D, 0)
+				return song
+			},
+			duration: 6 * rowDuration,
+		},
+		{
+			name: "self jump",
+			song: func() *testSong {
+				song := newTestSong(1, 4)
+				song.patterns[0][3][0] = fx(0x0B, 0)
+				return song
+			},
+			endless:  true,
+			duration: 4 * rowDuration,
+		},
+		{
+			name: "jump to the previous order",
+			song: func() *testSong {
+				song := newTestSong(1, 4, 4, 4)
+				song.patterns[2][1][0] = fx(0x0B, 1)
+				return song
+			},
+			endless:  true,
+			duration: 10 * rowDuration,
+		},
+		{
+			name: "forward jump",
+			song: func() *testSong {
+				song := newTestSong(1, 4, 4, 4)
+				song.patterns[0][1][0] = fx(0x0B, 2)
+				return song
+			},
+			duration: 6 * rowDuration,
+		},
+		{
+			name: "pattern loop",
+			song: func() *testSong {
+				song := newTestSong(1, 4)
+				song.patterns[0][1][0] = fx(0x0E, 0x60)
+				song.patterns[0][2][0] = fx(0x0E, 0x62)
+				return song
+			},
+			duration: (4 + 2*2) * rowDuration,
+		},
+		{
+			name: "pattern loop inside a jump loop",
+			song: func() *testSong {
+				song := newTestSong(1, 4)
+				song.patterns[0][1][0] = fx(0x0E, 0x60)
+				song.patterns[0][2][0] = fx(0x0E, 0x62)
+				song.patterns[0][3][0] = fx(0x0B, 0)
+				return song
+			},
+			endless:  true,
+			duration: (4 + 2*2) * rowDuration,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestStream(t, test.song(), LoadModuleConfig{})
+			if endless := s.HasEndlessLoop(); endless != test.endless {
+				t.Fatalf("HasEndlessLoop() = %v, want %v", endless, test.endless)
+			}
+			d := s.Duration()
+			if d.Round(time.Millisecond) != test.duration {
+				t.Fatalf("Duration() = %v, want %v", d, test.duration)
+			}
+			if test.endless {
+				return
+			}
+			numFrames := len(readAll(t, s)) / 4
+			if numFrames != s.durationToFrames(test.duration) {
+				t.Fatalf("rendered %d frames, want %d", numFrames, s.durationToFrames(test.duration))
+			}
+		})
+	}
+}
+
+func TestRowTracker(t *testing.T) {
+	tracker := newRowTracker(2)
+	for _, pos := range [][2]int{{0, 0}, {0, 255}, {1, 0}} {
+		if !tracker.Visit(pos[0], pos[1]) {
+			t.Fatalf("order=%d row=%d: the first visit is reported as a repeated one", pos[0], pos[1])
+		}
+	}
+	if tracker.looped {
+		t.Fatal("no rows were visited twice")
+	}
+	if tracker.Visit(0, 255) || !tracker.looped {
+		t.Fatal("the second visit of order=0 row=255 is not detected")
+	}
+
+	tracker.Reset()
+	if !tracker.Visit(0, 255) || tracker.looped {
+		t.Fatal("Reset didn't clear the visited rows")
+	}
+}
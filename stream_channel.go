@@ -9,11 +9,23 @@ const (
 )
 
 type streamChannel struct {
-	// These values are used in the hottest code path (readTick).
+	// These values are used in the hottest code path (mixTick).
 	// Keep them closer to the head of the struct.
 	computedVolume [2]float64
 	targetVolume   [2]float64
-	sampleOffset   float64
+	samplePos      uint64 // A 32.32 fixed-point sample position (see toSamplePos)
+	samplePosStep  uint64 // A fixed-point version of sampleStep
+	auxVolume      float64
+	targetAux      float64
+
+	// The channel signal filter (see Stream.SetChannelFilter).
+	filter biquadFilter
+
+	// Note cache state (see noteCache).
+	// When cached is not nil, the samplePos is not updated.
+	cached      []float32
+	cachedFrame int
+	cachedStep  float64
 
 	// Note-related data.
 	inst       *instrument
@@ -22,6 +34,7 @@ type streamChannel struct {
 	sampleStep float64
 	effect     effectKey
 	keyOn      bool
+	noteValue  uint8 // The XM note value of the last triggered note
 
 	panning float64
 
@@ -36,25 +49,71 @@ type streamChannel struct {
 	arpeggioRunning    bool
 	arpeggioNoteOffset float64
 
-	panningSlideValue      float64
-	volumeSlideValue       float64
-	globalVolumeSlideValue float64
-	portamentoUpValue      float64
-	portamentoDownValue    float64
+	panningSlideValue            float64
+	volumeSlideValue             float64
+	globalVolumeSlideValue       float64
+	portamentoUpValue            float64
+	portamentoDownValue          float64
+	finePortamentoUpValue        float64
+	finePortamentoDownValue      float64
+	fineVolumeSlideUpValue       float64
+	fineVolumeSlideDownValue     float64
+	extraFinePortamentoUpValue   float64
+	extraFinePortamentoDownValue float64
 
 	notePortamentoTargetPeriod float64
 	notePortamentoValue        float64
 
+	// Glissando control state (see EffectGlissandoControl).
+	// A non-zero glissandoPeriod overrides the period for the playback.
+	glissando       bool
+	glissandoPeriod float64
+
 	// Vibrato effect state.
+	// The vibratoPeriodOffset is added to the period when computing the frequency.
 	vibratoRunning      bool
 	vibratoPeriodOffset float64
-	vibratoDepth        float64
-	vibratoStep         uint8
+	vibratoDepth        uint8
+	vibratoPos          uint8
 	vibratoSpeed        uint8
+	vibratoWaveform     waveformKind
+	vibratoNoRetrigger  bool
+
+	// Tremolo effect state.
+	// The tremoloVolumeOffset is added to the volume when computing the output volume.
+	tremoloRunning      bool
+	tremoloVolumeOffset float64
+	tremoloDepth        uint8
+	tremoloPos          uint8
+	tremoloSpeed        uint8
+	tremoloWaveform     waveformKind
+	tremoloNoRetrigger  bool
+
+	// A state of the random waveform generator.
+	rng uint32
+
+	// Multi retrigger effect state.
+	multiRetriggerInterval uint8
+	multiRetriggerVolume   uint8
+	multiRetriggerCounter  uint8
+
+	// Pattern loop effect state.
+	patternLoopRow     int
+	patternLoopCounter int
 
 	// Ping-pong loop state.
 	reverse bool
 
+	// Note delay effect state.
+	// When delayedNote is not nil, the row processing
+	// for this channel is postponed until the noteDelayTick.
+	delayedNote   *patternNote
+	noteDelayTick int
+
+	// A note storage for the notes that are not coming from
+	// the pattern data (see Stream.TriggerNote).
+	triggeredNote patternNote
+
 	volumeEnvelope  envelopeRunner
 	panningEnvelope envelopeRunner
 
@@ -102,6 +161,17 @@ func (ch *streamChannel) assignNote(n *patternNote) {
 		return
 	}
 
+	notePeriod := n.period
+	if noteKind == noteGhost {
+		// The ghost note period depends on the current instrument.
+		p, ok := calcNotePeriod(n.raw, ch.inst)
+		if !ok {
+			// This instrument can't play this note; it's a no-op.
+			return
+		}
+		notePeriod = p
+	}
+
 	hasNotePortamento := n.flags.Contains(noteHasNotePortamento)
 	if !hasNotePortamento && noteKind == noteNormal {
 		if n.flags.Contains(noteBadInstrument) {
@@ -109,15 +179,7 @@ func (ch *streamChannel) assignNote(n *patternNote) {
 			ch.inst = nil
 			ch.volume = 0
 		} else {
-			// Read some trailing samples for the transition.
-			if ch.inst == nil {
-				ch.rampSamples = [32]float64{}
-			} else {
-				for i := range ch.rampSamples {
-					ch.rampSamples[i] = float64(ch.NextSample())
-				}
-			}
-			ch.rampFrame = 0
+			ch.captureRampSamples()
 			ch.inst = n.inst
 			ch.volumeEnvelope.envelope = n.inst.volumeEnvelope
 			ch.panningEnvelope.envelope = n.inst.panningEnvelope
@@ -126,18 +188,22 @@ func (ch *streamChannel) assignNote(n *patternNote) {
 
 	ch.vibratoPeriodOffset = 0
 	ch.keyOn = true
-	ch.resetEnvelopes()
+	if !hasNotePortamento && noteKind != noteGhost {
+		// Only a genuine (re)trigger restarts the envelopes.
+		// Portamento-linked notes and ghost notes continue
+		// from the current envelope positions.
+		ch.resetEnvelopes()
+		ch.resetWaveforms()
+		ch.multiRetriggerCounter = 0
+	}
 
 	if !hasNotePortamento && n.flags.Contains(noteValid) {
-		if n.period == 0 {
-			ch.period = linearPeriod(calcRealNote(n.raw, ch.inst))
-		} else {
-			ch.period = n.period
-		}
+		ch.period = notePeriod
+		ch.noteValue = uint8(n.raw)
 	}
 
 	if !hasNotePortamento && noteKind != noteGhostInstrument {
-		ch.sampleOffset = 0
+		ch.SetSampleOffset(0)
 		ch.reverse = false
 	}
 
@@ -149,30 +215,222 @@ func (ch *streamChannel) assignNote(n *patternNote) {
 	}
 }
 
-func (ch *streamChannel) NextSample() int16 {
-	sampleOffset := int(ch.sampleOffset)
-	if sampleOffset >= len(ch.inst.samples) {
-		return 0
+// captureRampSamples reads some trailing samples of the current note.
+// They're used for a smooth transition to the next note.
+func (ch *streamChannel) captureRampSamples() {
+	if ch.inst == nil {
+		ch.rampSamples = [numRampPoints]float64{}
+	} else {
+		for i := range ch.rampSamples {
+			ch.rampSamples[i] = float64(ch.NextSample())
+		}
 	}
+	ch.rampFrame = 0
+}
+
+func (ch *streamChannel) resetWaveforms() {
+	if !ch.vibratoNoRetrigger {
+		ch.vibratoPos = 0
+	}
+	if !ch.tremoloNoRetrigger {
+		ch.tremoloPos = 0
+	}
+}
 
-	v := ch.inst.samples[sampleOffset]
+// retrigger restarts the current note from the beginning.
+// The note period and volume are not changed.
+func (ch *streamChannel) retrigger() {
+	if ch.inst == nil {
+		return
+	}
+	ch.captureRampSamples()
+	ch.SetSampleOffset(0)
+	ch.reverse = false
+	ch.keyOn = true
+	ch.resetEnvelopes()
+	ch.resetWaveforms()
+}
+
+// outputVolume returns the channel volume with all modulations applied.
+func (ch *streamChannel) outputVolume() float64 {
+	volume := ch.volume
+	if ch.tremoloVolumeOffset != 0 {
+		volume = clamp(volume+ch.tremoloVolumeOffset, 0, 1)
+	}
+	return volume * ch.fadeoutVolume * ch.volumeEnvelope.value
+}
+
+// outputPanning returns the channel panning with the envelope applied.
+func (ch *streamChannel) outputPanning() float64 {
+	return ch.panning + (ch.panningEnvelope.value-0.5)*(0.5-abs(ch.panning-0.5))*2
+}
+
+// outputPeriod returns the channel period with all pitch modulations applied.
+func (ch *streamChannel) outputPeriod() float64 {
+	period := ch.period
+	if ch.glissandoPeriod != 0 {
+		period = ch.glissandoPeriod
+	}
+	return period - (64 * ch.arpeggioNoteOffset) + ch.vibratoPeriodOffset
+}
+
+// SetSampleOffset assigns a new sample position.
+// The sample position should only be changed via this method.
+func (ch *streamChannel) SetSampleOffset(offset float64) {
+	ch.SetSamplePos(toSamplePos(offset))
+}
 
-	ch.sampleOffset += ch.sampleStep
-	if ch.sampleOffset >= ch.inst.loopEnd {
-		for ch.sampleOffset >= ch.inst.loopEnd {
-			ch.sampleOffset -= ch.inst.loopLength
+// SetSamplePos is like SetSampleOffset, but it accepts a fixed-point position.
+func (ch *streamChannel) SetSamplePos(pos uint64) {
+	ch.cached = nil
+	ch.samplePos = pos
+}
+
+// SetSampleStep assigns the sample position increment per output frame.
+func (ch *streamChannel) SetSampleStep(step float64) {
+	ch.sampleStep = step
+	ch.samplePosStep = toSamplePos(step)
+}
+
+func (ch *streamChannel) NextSample() float32 {
+	if ch.cached != nil {
+		if ch.cachedFrame >= len(ch.cached) {
+			return 0
 		}
+		v := ch.cached[ch.cachedFrame]
+		ch.cachedFrame++
+		return v
+	}
+
+	// The integer part of the fixed-point position is a frame index.
+	// The offset is never negative, so this shift is equivalent to a floor operation.
+	sampleIndex := ch.samplePos >> samplePosFracBits
+	if sampleIndex >= uint64(len(ch.inst.samples)) {
+		return 0
+	}
+
+	v := ch.inst.sampleAt(ch.samplePos)
+
+	ch.samplePos += ch.samplePosStep
+	if ch.samplePos >= ch.inst.loopEndPos {
+		// The step can be bigger than the loop itself (high pitch and a tiny loop),
+		// so we can't just subtract the loop length once.
+		// Ping-pong loops are unrolled into forward loops by the compiler,
+		// so this wrapping produces a correct triangle-shaped position for them too.
+		ch.samplePos = ch.inst.loopStartPos + (ch.samplePos-ch.inst.loopStartPos)%ch.inst.loopLengthPos
 	}
 
 	return v
 }
 
+// slideVolumes moves the output volumes one frame closer to their targets.
+//
+// The volume changes are ramped instead of being applied instantly:
+// an abrupt volume jump produces an audible click.
+func (ch *streamChannel) slideVolumes(step float64) {
+	ch.computedVolume[0] = slideTowards(ch.computedVolume[0], ch.targetVolume[0], step)
+	ch.computedVolume[1] = slideTowards(ch.computedVolume[1], ch.targetVolume[1], step)
+	ch.auxVolume = slideTowards(ch.auxVolume, ch.targetAux, step)
+}
+
+// IsRamping reports whether some of the output volumes haven't reached their targets yet.
+func (ch *streamChannel) IsRamping() bool {
+	return ch.computedVolume != ch.targetVolume || ch.auxVolume != ch.targetAux
+}
+
+// ReadSamples fills dst with the next samples.
+// It's a faster version of the NextSample loop:
+// the playback state is kept in the local variables.
+func (ch *streamChannel) ReadSamples(dst []float64) {
+	if ch.cached != nil {
+		for i := range dst {
+			dst[i] = float64(ch.NextSample())
+		}
+		return
+	}
+
+	inst := ch.inst
+	samples := inst.samples
+	numSamples := uint64(len(samples))
+	pos := ch.samplePos
+	step := ch.samplePosStep
+	loopEnd := inst.loopEndPos
+	if inst.interpolation == InterpolationNone {
+		for i := range dst {
+			sampleIndex := pos >> samplePosFracBits
+			if sampleIndex >= numSamples {
+				// The sample is over, the rest is silence.
+				for j := i; j < len(dst); j++ {
+					dst[j] = 0
+				}
+				break
+			}
+			dst[i] = float64(samples[sampleIndex])
+			pos += step
+			if pos >= loopEnd {
+				pos = inst.loopStartPos + (pos-inst.loopStartPos)%inst.loopLengthPos
+			}
+		}
+		ch.samplePos = pos
+		return
+	}
+
+	if inst.interpolation != InterpolationLinear {
+		// The higher quality modes are expensive on their own,
+		// the sampleAt call overhead is negligible here.
+		for i := range dst {
+			if pos>>samplePosFracBits >= numSamples {
+				for j := i; j < len(dst); j++ {
+					dst[j] = 0
+				}
+				break
+			}
+			dst[i] = float64(inst.sampleAt(pos))
+			pos += step
+			if pos >= loopEnd {
+				pos = inst.loopStartPos + (pos-inst.loopStartPos)%inst.loopLengthPos
+			}
+		}
+		ch.samplePos = pos
+		return
+	}
+
+	// This is an inlined version of the sampleAt linear interpolation.
+	wrapFrame := inst.wrapFrame
+	for i := range dst {
+		sampleIndex := pos >> samplePosFracBits
+		if sampleIndex >= numSamples {
+			for j := i; j < len(dst); j++ {
+				dst[j] = 0
+			}
+			break
+		}
+		u := float32(samples[sampleIndex])
+		var v float32
+		if sampleIndex+1 < wrapFrame {
+			v = float32(samples[sampleIndex+1])
+		} else {
+			v = float32(inst.frameAt(int(sampleIndex) + 1))
+		}
+		t := float32(pos&(1<<samplePosFracBits-1)) * samplePosFracScale
+		dst[i] = float64(u + t*(v-u))
+		pos += step
+		if pos >= loopEnd {
+			pos = inst.loopStartPos + (pos-inst.loopStartPos)%inst.loopLengthPos
+		}
+	}
+	ch.samplePos = pos
+}
+
 func (ch *streamChannel) IsActive() bool {
 	if ch.inst == nil {
 		return false
 	}
+	if ch.cached != nil {
+		return ch.cachedFrame < len(ch.cached)
+	}
 	if ch.inst.loopType == xmfile.SampleLoopNone {
-		if int(ch.sampleOffset) >= len(ch.inst.samples) {
+		if ch.samplePos>>samplePosFracBits >= uint64(len(ch.inst.samples)) {
 			return false
 		}
 	}
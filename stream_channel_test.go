@@ -0,0 +1,78 @@
+package xm
+
+import (
+	"testing"
+)
+
+func TestPingPongLoopLargeStep(t *testing.T) {
+	const (
+		loopStart  = 2
+		loopLength = 5
+	)
+	inst := testInstrument{volume: 64, loopType: 2, loopStart: loopStart, loopLength: loopLength}
+	// The frames after the loop end are never played.
+	for i := 0; i < loopStart+loopLength+3; i++ {
+		inst.data = append(inst.data, int8(i*10))
+	}
+	song := newTestSong(1, 1)
+	song.instruments = []testInstrument{inst}
+
+	// triangleIndex returns the frame index that should be played
+	// at the given position if the sample was played infinitely
+	// with the ping-pong loop: 0 1 [2 3 4 5 6 5 4 3] [2 3 4 ...
+	triangleIndex := func(pos int) int {
+		if pos < loopStart {
+			return pos
+		}
+		period := 2*loopLength - 2
+		t := (pos - loopStart) % period
+		if t >= loopLength {
+			t = period - t
+		}
+		return loopStart + t
+	}
+
+	// The steps are bigger than the loop and the unrolled loop.
+	steps := []float64{1, 3, 5.5, 9, 11.25, 37.75}
+	for _, step := range steps {
+		s := newTestStream(t, song, LoadModuleConfig{Interpolation: InterpolationNone})
+		ch := &streamChannel{inst: &s.module.instruments[0]}
+		ch.SetSampleStep(step)
+		ch2 := &streamChannel{inst: &s.module.instruments[0]}
+		ch2.SetSampleStep(step)
+		block := make([]float64, 64)
+		ch2.ReadSamples(block)
+		for i := 0; i < 64; i++ {
+			want := float32(triangleIndex(int(float64(i)*step)) * 10 << 8)
+			if v := ch.NextSample(); v != want {
+				t.Fatalf("step=%v: frame %d is %v, want %v", step, i, v, want)
+			}
+			if v := float32(block[i]); v != want {
+				t.Fatalf("step=%v: ReadSamples frame %d is %v, want %v", step, i, v, want)
+			}
+		}
+	}
+}
+
+func TestSamplePosAccumulation(t *testing.T) {
+	inst := testInstrument{volume: 64, data: make([]int8, 60000)}
+	song := newTestSong(1, 1)
+	song.instruments = []testInstrument{inst}
+	s := newTestStream(t, song, LoadModuleConfig{})
+
+	// A step that can't be represented exactly.
+	// The fixed-point position must not drift: after N frames
+	// it's exactly N steps away from the start.
+	const numFrames = 150000
+	ch := &streamChannel{inst: &s.module.instruments[0]}
+	ch.SetSampleStep(1.0 / 3)
+	for i := 0; i < numFrames; i++ {
+		ch.NextSample()
+	}
+	if want := numFrames * toSamplePos(1.0/3); ch.samplePos != want {
+		t.Fatalf("samplePos is %d, want %d", ch.samplePos, want)
+	}
+	if index := ch.samplePos >> samplePosFracBits; index != numFrames/3-1 {
+		t.Fatalf("sample index is %d, want %d", index, numFrames/3-1)
+	}
+}
@@ -0,0 +1,71 @@
+package xm
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// streamControl synchronizes the stream access between the goroutines.
+//
+// The stream state is guarded by mu; the audio goroutine holds it
+// during the Read call. The control methods (like SetVolume) never wait
+// for the lock: if the stream is busy, they're queued and applied
+// at the next tick boundary (or by the next method that takes the lock).
+// This also makes it possible to call them from the playback handlers.
+//
+// The control object is shared by all stream states, so it survives the rewinds.
+type streamControl struct {
+	mu sync.Mutex
+
+	queueMu sync.Mutex
+	queue   []func()
+
+	// numQueued is len(queue); it makes the empty queue check cheap.
+	numQueued atomic.Int32
+}
+
+// lock acquires the stream lock and applies the queued commands,
+// so the caller observes the effects of all control methods called before.
+func (s *Stream) lock() {
+	s.ctl.mu.Lock()
+	s.applyCommands()
+}
+
+func (s *Stream) unlock() {
+	s.ctl.mu.Unlock()
+}
+
+// control executes f if the stream is not busy.
+// Otherwise f is queued (see streamControl).
+// The commands are always executed in the order they were issued.
+func (s *Stream) control(f func()) {
+	if s.ctl.mu.TryLock() {
+		s.applyCommands()
+		f()
+		s.ctl.mu.Unlock()
+		return
+	}
+	c := s.ctl
+	c.queueMu.Lock()
+	c.queue = append(c.queue, f)
+	c.numQueued.Store(int32(len(c.queue)))
+	c.queueMu.Unlock()
+}
+
+// applyCommands executes the queued commands.
+// It should be called with the stream lock held.
+func (s *Stream) applyCommands() {
+	c := s.ctl
+	for c.numQueued.Load() != 0 {
+		c.queueMu.Lock()
+		queue := c.queue
+		c.queue = nil
+		c.numQueued.Store(0)
+		c.queueMu.Unlock()
+		// The queue lock is released while the commands are executed:
+		// they may call the handlers which can issue new commands.
+		for _, f := range queue {
+			f()
+		}
+	}
+}
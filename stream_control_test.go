@@ -0,0 +1,92 @@
+package xm
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentControl(t *testing.T) {
+	song := newTestSong(2, 16, 16)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[1][0][1] = n(61, 1)
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetLooping(true)
+
+	// This test is mostly useful with the -race flag.
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 1000)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := s.Read(buf); err != nil && err != io.EOF {
+				t.Errorf("read: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		s.SetVolume(float64(i%10) / 10)
+		s.SetChannelMuted(i%2, i%3 == 0)
+		switch i % 4 {
+		case 0:
+			s.Rewind()
+		case 1:
+			if err := s.SeekTo(1, i%16); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pos := s.GetPosition()
+		if pos.Order < 0 || pos.Order > 1 || pos.Row < 0 || pos.Row >= 16 {
+			t.Fatalf("invalid position: %+v", pos)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestHandlerCallsSetter(t *testing.T) {
+	song := newTestSong(1, 8)
+	song.patterns[0][0][0] = n(49, 1)
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.OnRow(func(order, pattern, row int) {
+		// The stream is locked during the handler call,
+		// the setters are applied at the next tick boundary.
+		if row == 4 {
+			s.SetChannelMuted(0, true)
+		}
+	})
+
+	done := make(chan []byte)
+	go func() {
+		done <- readAll(t, s)
+	}()
+	var data []byte
+	select {
+	case data = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Read is blocked by the handler setter call")
+	}
+
+	rowBytes := len(data) / 8
+	if peakLevel(data[:4*rowBytes]) == 0 {
+		t.Fatal("the channel is silent before the mute")
+	}
+	// The mute is applied after the row 4 first tick,
+	// the volume ramp makes the channel fade out during the next tick.
+	tickBytes := rowBytes / 6
+	if peak := peakLevel(data[4*rowBytes+2*tickBytes:]); peak != 0 {
+		t.Fatalf("the muted channel is audible: peak=%v", peak)
+	}
+}
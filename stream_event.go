@@ -18,6 +18,7 @@ const (
 	// EventNote is emitted every time a channel starts to play some note.
 	// It can be triggered even of a "ghost note", so it's up to the application
 	// to decide whether they need to handle that note or not.
+	// The key-off notes produce EventKeyOff instead.
 	//
 	// Use StreamEvent.NoteEventData to get the event data.
 	//
@@ -36,6 +37,16 @@ const (
 	//
 	// Experimental: the events handling API may change significantly in the future.
 	EventSync
+
+	// EventKeyOff is emitted every time a channel releases its note.
+	// This includes the key-off notes and the key-off effects (Kxx).
+	// The note continues to play (fading out) if the instrument
+	// has a volume envelope, otherwise it's silenced right away.
+	//
+	// Use StreamEvent.KeyOffEventData to get the event data.
+	//
+	// Experimental: the events handling API may change significantly in the future.
+	EventKeyOff
 )
 
 // StreamEvent holds a single Stream event data.
@@ -44,6 +55,7 @@ const (
 // To handle the event correctly, you must first check its kind.
 // For an event of kind EventNote there is a NoteEventData method that
 // will return the associated data. For EventSync there is a SyncEventData.
+// For EventKeyOff there is a KeyOffEventData.
 //
 // Every event has a Time value. This is a moment when this event happened in
 // relation to the XM track start (in seconds). The user application needs
@@ -86,3 +98,16 @@ func (e StreamEvent) NoteEventData() (note, instrument int, vol float32) {
 func (e StreamEvent) SyncEventData() (t float64) {
 	return math.Float64frombits(e.value)
 }
+
+// KeyOffEventData returns the event data if e.Kind=EventKeyOff.
+// The return values are: note, instrument (id).
+// The note is the last note triggered on the channel.
+// If there is no instrument, -1 is returned.
+func (e StreamEvent) KeyOffEventData() (note, instrument int) {
+	noteBits := e.value & 0xff
+	instrumentID := int((e.value >> 8) & 0xff)
+	if instrumentID == 255 {
+		instrumentID = -1
+	}
+	return int(noteBits), instrumentID
+}
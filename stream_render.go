@@ -0,0 +1,124 @@
+package xm
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// RenderRange renders the specified song fragment into PCM bytes.
+//
+// The output format is identical to what Read produces.
+// The rendering starts from the song beginning, but the audio
+// before the start offset is not mixed, only the playback state is simulated.
+// This means that the effects state (volume slides, tempo changes, etc.)
+// is reconstructed correctly; the result matches the same slice
+// of the whole song rendered via Read.
+//
+// If end goes past the song end, the result is truncated.
+// If start goes past the song end, an empty slice is returned.
+// For songs with endless loops (see HasEndlessLoop), the song end
+// is the point where the loop starts its second iteration.
+//
+// This method does not affect the stream playback state.
+func (s *Stream) RenderRange(start, end time.Duration) ([]byte, error) {
+	s.lock()
+	defer s.unlock()
+
+	if start < 0 {
+		return nil, errors.New("negative start offset")
+	}
+	if start >= end {
+		return nil, errors.New("start offset should be less than the end offset")
+	}
+
+	startFrame := s.durationToFrames(start)
+	endFrame := s.durationToFrames(end)
+
+	sim := s.cloneForAnalysis()
+	// The end offset can be much farther than the song end,
+	// so the result is not preallocated; it grows as the ticks are mixed.
+	var result []byte
+	var mix []float64
+	pos := 0
+	for pos < endFrame && sim.nextTick() {
+		// The tick size can change during the playback (see Fxx effect).
+		numFrames := sim.framesPerTick
+		if pos+numFrames <= startFrame {
+			sim.skipTick()
+			pos += numFrames
+			continue
+		}
+		mix = sim.mixBuffer(mix)
+		sim.mixTick(mix)
+		from := clampMin(startFrame-pos, 0)
+		to := clampMax(endFrame-pos, numFrames)
+		result = appendFrames(result, mix[from*2:to*2], s.module.format, s.module.bigEndian)
+		pos += numFrames
+	}
+
+	return result, nil
+}
+
+// mixBuffer returns a slice that can fit exactly one current tick mixed frames.
+// The buf memory is reused if possible.
+func (s *Stream) mixBuffer(buf []float64) []float64 {
+	n := 2 * s.framesPerTick
+	if cap(buf) < n {
+		return make([]float64, n)
+	}
+	return buf[:n]
+}
+
+// durationToFrames converts a duration into a number of frames.
+// The result is saturated, so huge durations (like math.MaxInt64)
+// don't overflow the int even on 32-bit platforms.
+func (s *Stream) durationToFrames(d time.Duration) int {
+	return int(math.Min(d.Seconds()*s.module.sampleRate, math.MaxInt32))
+}
+
+// RenderPattern renders the specified pattern order entry into PCM bytes.
+//
+// The output format is identical to what Read produces.
+// The pattern is rendered using the playback state at the moment it starts playing:
+// all the preceding patterns are simulated (but not mixed) to get the correct
+// tempo, volumes, and other effects state. The channels that were playing
+// notes before this pattern started will continue to play them.
+//
+// The rendered audio ends when the playback leaves the specified pattern order entry,
+// so the patterns that are shortened by the pattern breaks produce shorter clips.
+// If the order entry is played several times, only the first playback is rendered.
+// If the order entry is never reached, an error is returned.
+//
+// This method does not affect the stream playback state.
+func (s *Stream) RenderPattern(orderIndex int) ([]byte, error) {
+	s.lock()
+	defer s.unlock()
+
+	if orderIndex < 0 || orderIndex >= len(s.module.patternOrder) {
+		return nil, errors.New("pattern order index is out of range")
+	}
+
+	sim := s.cloneForAnalysis()
+	var result []byte
+	var mix []float64
+	started := false
+	for sim.nextTick() {
+		if sim.patternIndex != orderIndex {
+			if started {
+				break
+			}
+			sim.skipTick()
+			continue
+		}
+		started = true
+		mix = sim.mixBuffer(mix)
+		sim.mixTick(mix)
+		result = appendFrames(result, mix, s.module.format, s.module.bigEndian)
+	}
+
+	if !started {
+		return nil, errors.New("pattern order entry is never played")
+	}
+	return result, nil
+}
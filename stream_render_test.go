@@ -0,0 +1,92 @@
+package xm
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRenderRange(t *testing.T) {
+	song := newTestSong(2, 16, 16)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][4][1] = n(61, 1)
+	song.patterns[0][8][0] = testNote{fx: 0x0A, param: 0x04, filled: true}
+	song.patterns[1][0][0] = testNote{note: 56, inst: 1, fx: 0x0F, param: 3, filled: true}
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	full := readAll(t, s)
+	if peakLevel(full) == 0 {
+		t.Fatal("the test song is silent")
+	}
+	frameSize := 4
+	frameDuration := func(numFrames int) time.Duration {
+		return time.Duration(float64(numFrames) / 44100 * float64(time.Second))
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Duration
+	}{
+		{"whole song", 0, time.Hour},
+		{"song beginning", 0, 500 * time.Millisecond},
+		{"mid tick", 123 * time.Millisecond, 1234 * time.Millisecond},
+		{"after tempo change", 2 * time.Second, 2500 * time.Millisecond},
+		{"huge end", time.Second, math.MaxInt64},
+		{"past the end", time.Hour, 2 * time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered, err := s.RenderRange(test.start, test.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from := clampMax(s.durationToFrames(test.start)*frameSize, len(full))
+			to := clampMax(s.durationToFrames(test.end)*frameSize, len(full))
+			if !bytes.Equal(rendered, full[from:to]) {
+				t.Fatalf("RenderRange(%v, %v) doesn't match the full render slice [%d:%d] (got %d bytes)",
+					test.start, test.end, from, to, len(rendered))
+			}
+		})
+	}
+
+	if _, err := s.RenderRange(frameDuration(10), frameDuration(5)); err == nil {
+		t.Fatal("expected an error for start > end")
+	}
+}
+
+func TestRenderPattern(t *testing.T) {
+	song := newTestSong(1, 8, 8, 8)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][4][0] = fx(0x0F, 3)   // Faster tempo starting from the middle of the pattern
+	song.patterns[1][2][0] = fx(0x0D, 0)   // Break: only 3 rows are played
+	song.patterns[2][1][0] = fx(0x0F, 100) // Slower BPM
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	durations := s.PatternDurations()
+	full := readAll(t, s)
+
+	offset := 0
+	for i, d := range durations {
+		clip, err := s.RenderPattern(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		numFrames := len(clip) / 4
+		wantFrames := d.Seconds() * 44100
+		if math.Abs(float64(numFrames)-wantFrames) > 1 {
+			t.Fatalf("pattern[%d] clip is %d frames long, want %.1f", i, numFrames, wantFrames)
+		}
+		if !bytes.Equal(clip, full[offset:offset+len(clip)]) {
+			t.Fatalf("pattern[%d] clip doesn't match the full render", i)
+		}
+		offset += len(clip)
+	}
+	if offset != len(full) {
+		t.Fatalf("the clips total length is %d bytes, want %d", offset, len(full))
+	}
+
+	if _, err := s.RenderPattern(3); err == nil {
+		t.Fatal("expected an error for the out of range order index")
+	}
+}
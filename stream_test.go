@@ -0,0 +1,1512 @@
+package xm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/quasilyte/xm/xmfile"
+)
+
+// readTicks reads the stream tick by tick and returns the rendered bytes.
+func readTicks(t *testing.T, s *Stream, numTicks int) []byte {
+	t.Helper()
+	var result []byte
+	for i := 0; i < numTicks; i++ {
+		tick := make([]byte, s.framesPerTick*s.module.frameSize)
+		if _, err := io.ReadFull(s, tick); err != nil {
+			t.Fatalf("tick %d: %v", i, err)
+		}
+		result = append(result, tick...)
+	}
+	return result
+}
+
+func TestTriggerNote(t *testing.T) {
+	song := newTestSong(2, 64)
+	s := newTestStream(t, song, LoadModuleConfig{})
+
+	if peakLevel(readTicks(t, s, 1)) != 0 {
+		t.Fatal("the song should be silent")
+	}
+
+	if err := s.TriggerNote(0, 49, 1); err != nil {
+		t.Fatal(err)
+	}
+	if peakLevel(readTicks(t, s, 1)) == 0 {
+		t.Fatal("the triggered note is not audible")
+	}
+
+	errorTests := []struct {
+		instrument, note, channel int
+	}{
+		{-1, 49, 0},
+		{1, 49, 0},
+		{0, 0, 0},
+		{0, 97, 0},
+		{0, 49, -1},
+		{0, 49, 2},
+	}
+	for _, test := range errorTests {
+		if err := s.TriggerNote(test.instrument, test.note, test.channel); err == nil {
+			t.Errorf("TriggerNote(%d, %d, %d): expected an error", test.instrument, test.note, test.channel)
+		}
+	}
+}
+
+func TestInstrumentNames(t *testing.T) {
+	song := newTestSong(1, 4)
+	lead := sineInstrument(1)
+	lead.name = "lead  "
+	lead.sampleName = " saw\x00garbage"
+	bass := sineInstrument(1)
+	bass.name = "bass"
+	bass.sampleName = "sub bass"
+	song.instruments = []testInstrument{lead, bass}
+
+	m := song.parse(t)
+	s := NewStream()
+	if err := s.LoadModule(m, LoadModuleConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	names := s.InstrumentNames()
+	if len(names) != len(m.Instruments) {
+		t.Fatalf("got %d instrument names, want %d", len(names), len(m.Instruments))
+	}
+	wantNames := []string{"lead", "bass"}
+	wantSampleNames := []string{" saw", "sub bass"}
+	for i, inst := range m.Instruments {
+		if names[i] != wantNames[i] {
+			t.Errorf("instrument[%d] name is %q, want %q (source name is %q)", i, names[i], wantNames[i], inst.Name)
+		}
+		sampleNames := s.SampleNames(i)
+		if len(sampleNames) != len(inst.Samples) {
+			t.Fatalf("instrument[%d]: got %d sample names, want %d", i, len(sampleNames), len(inst.Samples))
+		}
+		if sampleNames[0] != wantSampleNames[i] {
+			t.Errorf("instrument[%d] sample name is %q, want %q (source name is %q)",
+				i, sampleNames[0], wantSampleNames[i], inst.Samples[0].Name)
+		}
+	}
+
+	if s.SampleNames(-1) != nil || s.SampleNames(2) != nil {
+		t.Error("expected nil sample names for the out of range instruments")
+	}
+}
+
+// readTickFrequencies reads the stream tick by tick
+// and collects the channel frequencies after every tick.
+func readTickFrequencies(t *testing.T, s *Stream, channel, numTicks int) []float64 {
+	t.Helper()
+	result := make([]float64, numTicks)
+	for i := range result {
+		readTicks(t, s, 1)
+		result[i] = s.ChannelFrequencies()[channel]
+	}
+	return result
+}
+
+func TestNoteRange(t *testing.T) {
+	inst := sineInstrument(4)
+	inst.relNote = 24
+	inst.keymap = make([]byte, 96)
+	inst.keymap[61-1] = 1 // A non-existing sample: the note is not mapped
+	song := newTestSong(1, 8)
+	song.instruments = []testInstrument{inst}
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][2][0] = n(61, 1)
+	song.patterns[0][4][0] = n(49, 1)
+	song.patterns[0][6][0] = n(96, 1) // Out of range after adding the relative note
+
+	noteFrequency := func(realNote float64) float64 {
+		return linearFrequency(linearPeriod(realNote))
+	}
+	base := noteFrequency(49 + 24 - 1)
+
+	tests := []struct {
+		mode       NoteRangeMode
+		unmapped   float64
+		outOfRange float64
+	}{
+		{
+			mode:       NoteRangeIgnore,
+			unmapped:   base,
+			outOfRange: base,
+		},
+		{
+			mode:       NoteRangeClamp,
+			unmapped:   noteFrequency(61 + 24 - 1),
+			outOfRange: noteFrequency(10*12 - 1 - 1),
+		},
+	}
+	for _, test := range tests {
+		s := newTestStream(t, song, LoadModuleConfig{NoteRange: test.mode})
+		freqs := readTickFrequencies(t, s, 0, 6*8)
+		check := func(row int, want float64) {
+			have := freqs[row*6]
+			if math.Abs(have-want) > 0.01 {
+				t.Errorf("mode=%v: row %d frequency is %.2f, want %.2f", test.mode, row, have, want)
+			}
+		}
+		check(0, base)
+		check(2, test.unmapped)
+		check(4, base)
+		check(6, test.outOfRange)
+	}
+}
+
+func TestDegenerateTickSize(t *testing.T) {
+	song := newTestSong(1, 4)
+	m := song.parse(t)
+
+	s := NewStream()
+	err := s.LoadModule(m, LoadModuleConfig{BPM: 4000})
+	if err == nil {
+		t.Fatal("expected an error for a degenerate tick size")
+	}
+	const wantErr = "BPM=4000 with sample rate=44100 results in 28 samples per tick (need at least 32)"
+	if err.Error() != wantErr {
+		t.Fatalf("unexpected error:\nhave: %v\nwant: %s", err, wantErr)
+	}
+
+	if err := s.LoadModule(m, LoadModuleConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetBPM(4000); err == nil {
+		t.Fatal("SetBPM: expected an error for a degenerate tick size")
+	}
+	if err := s.SetBPM(0); err == nil {
+		t.Fatal("SetBPM: expected an error for a zero BPM")
+	}
+	if err := s.SetBPM(250); err != nil {
+		t.Fatalf("SetBPM: %v", err)
+	}
+}
+
+// testReferenceRender compares the testdata/sine.xm render
+// with the reference PCM file.
+//
+// The reference files contain the ideal renders of a C-5 note of the sine sample
+// (see testdata/README.md). The first frames are not compared,
+// they're affected by the note attack volume ramp.
+func testReferenceRender(t *testing.T, config LoadModuleConfig, refFilename string, tolerance int) {
+	t.Helper()
+
+	xmData, err := os.ReadFile("testdata/sine.xm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(refFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := xmfile.NewParser(xmfile.ParserConfig{}).ParseFromBytes(xmData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStream()
+	if err := s.LoadModule(m, config); err != nil {
+		t.Fatal(err)
+	}
+	s.SetReferenceMixing(true)
+	have := readAll(t, s)
+
+	if len(have) != len(want) {
+		t.Fatalf("render length is %d bytes, want %d", len(have), len(want))
+	}
+	const rampFrames = 256
+	haveFrames := pcmFrames(have)
+	wantFrames := pcmFrames(want)
+	for i := rampFrames; i < len(haveFrames); i++ {
+		for side := range haveFrames[i] {
+			diff := int(haveFrames[i][side]) - int(wantFrames[i][side])
+			if diff < -tolerance || diff > tolerance {
+				t.Fatalf("frame %d[%d]: have %d, want %d (tolerance is %d)",
+					i, side, haveFrames[i][side], wantFrames[i][side], tolerance)
+			}
+		}
+	}
+}
+
+func TestReferenceMixing(t *testing.T) {
+	testReferenceRender(t, LoadModuleConfig{}, "testdata/sine_linear.pcm", 2)
+}
+
+func TestInterpolationNoneTruncation(t *testing.T) {
+	// FastTracker II doesn't interpolate the samples:
+	// the sample position is truncated to the frame index.
+	// Any other rounding would play the frames earlier and
+	// the differences would be as large as the sample frames delta.
+	config := LoadModuleConfig{Interpolation: InterpolationNone}
+	testReferenceRender(t, config, "testdata/sine_truncated.pcm", 1)
+}
+
+// readRows reads the stream row by row (the tempo and BPM should not change).
+func readRows(t *testing.T, s *Stream, numRows, ticksPerRow int) [][]byte {
+	t.Helper()
+	rows := make([][]byte, numRows)
+	for i := range rows {
+		rows[i] = readTicks(t, s, ticksPerRow)
+	}
+	return rows
+}
+
+func TestPortamentoKeepsVolumeEnvelope(t *testing.T) {
+	// The envelope fades the note out in 30 ticks (5 rows).
+	inst := sineInstrument(4)
+	inst.volumeEnvelope = []xmfile.EnvelopePoint{{X: 0, Y: 64}, {X: 30, Y: 0}}
+	inst.volumeFlags = 1
+
+	tests := []struct {
+		name    string
+		note    testNote
+		audible bool
+	}{
+		{"normal note", n(61, 1), true},
+		{"portamento note", testNote{note: 61, inst: 1, fx: 0x03, param: 0x10, filled: true}, false},
+		{"ghost note", testNote{note: 61, filled: true}, false},
+	}
+	for _, test := range tests {
+		song := newTestSong(1, 8)
+		song.instruments = []testInstrument{inst}
+		song.patterns[0][0][0] = n(49, 1)
+		song.patterns[0][2][0] = test.note
+
+		s := newTestStream(t, song, LoadModuleConfig{})
+		rows := readRows(t, s, 7, 6)
+		if peakLevel(rows[2]) == 0 {
+			t.Fatalf("%s: the note is silent right after the row 2 note", test.name)
+		}
+		// The envelope has reached zero by row 6, unless it was restarted.
+		audible := peakLevel(rows[6]) != 0
+		if audible != test.audible {
+			t.Fatalf("%s: row 6 audible=%v, want %v", test.name, audible, test.audible)
+		}
+	}
+}
+
+func TestInstrumentVolume(t *testing.T) {
+	render := func(volume byte) []byte {
+		song := newTestSong(1, 2)
+		song.instruments[0].volume = volume
+		song.patterns[0][0][0] = n(49, 1)
+		s := newTestStream(t, song, LoadModuleConfig{})
+		return readAll(t, s)
+	}
+
+	fullData := render(0x40)
+	if peakLevel(fullData) == 0 {
+		t.Fatal("the note is silent")
+	}
+	// FastTracker II clamps the out of range sample volumes.
+	if !bytes.Equal(render(0x50), fullData) {
+		t.Fatal("volume 0x50 should play like 0x40")
+	}
+	full := pcmFrames(fullData)
+	half := pcmFrames(render(0x20))
+	if len(full) != len(half) {
+		t.Fatalf("render lengths mismatch: %d vs %d", len(full), len(half))
+	}
+	// The first frames are affected by the attack volume ramp.
+	for i := 256; i < len(full); i++ {
+		for side := range full[i] {
+			diff := int(full[i][side]) - 2*int(half[i][side])
+			if diff < -2 || diff > 2 {
+				t.Fatalf("frame %d[%d]: volume 0x40 gives %d, volume 0x20 gives %d",
+					i, side, full[i][side], half[i][side])
+			}
+		}
+	}
+}
+
+func TestSetSampleLoopType(t *testing.T) {
+	inst := sineInstrument(4)
+	inst.loopType = 0 // The loop bounds are kept, but the loop is disabled
+	song := newTestSong(1, 4)
+	song.instruments = []testInstrument{inst}
+	song.patterns[0][0][0] = n(49, 1)
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	rows := readRows(t, s, 4, 6)
+	if peakLevel(rows[0]) == 0 {
+		t.Fatal("the note is silent")
+	}
+	if peakLevel(rows[3]) != 0 {
+		t.Fatal("a non-looped sample should stop")
+	}
+
+	if err := s.SetSampleLoopType(0, 0, xmfile.SampleLoopForward); err != nil {
+		t.Fatal(err)
+	}
+	s.Rewind()
+	rows = readRows(t, s, 4, 6)
+	for i, row := range rows {
+		if peakLevel(row) == 0 {
+			t.Fatalf("row %d: a looped sample should sustain", i)
+		}
+	}
+
+	if err := s.SetSampleLoopType(1, 0, xmfile.SampleLoopForward); err == nil {
+		t.Fatal("expected an error for the out of range instrument")
+	}
+	if err := s.SetSampleLoopType(0, 1, xmfile.SampleLoopForward); err == nil {
+		t.Fatal("expected an error for the out of range sample")
+	}
+}
+
+func TestTickHandlerGranularity(t *testing.T) {
+	type call struct{ order, row, tick int }
+
+	tests := []struct {
+		granularity CallbackGranularity
+		numCalls    int
+		check       func(c call) bool
+	}{
+		{CallbackEveryTick, 2 * 4 * 6, func(c call) bool { return true }},
+		{CallbackEveryRow, 2 * 4, func(c call) bool { return c.tick == 0 }},
+		{CallbackEveryPattern, 2, func(c call) bool { return c.tick == 0 && c.row == 0 }},
+	}
+	for _, test := range tests {
+		s := newTestStream(t, newTestSong(1, 4, 4), LoadModuleConfig{})
+		var calls []call
+		s.SetTickHandler(test.granularity, func(order, row, tick int) {
+			calls = append(calls, call{order, row, tick})
+		})
+		readAll(t, s)
+
+		if len(calls) != test.numCalls {
+			t.Fatalf("granularity=%v: got %d calls, want %d", test.granularity, len(calls), test.numCalls)
+		}
+		for _, c := range calls {
+			if !test.check(c) {
+				t.Fatalf("granularity=%v: unexpected call %+v", test.granularity, c)
+			}
+		}
+	}
+}
+
+func TestChannelEffects(t *testing.T) {
+	song := newTestSong(3, 4)
+	song.patterns[0][1][0] = testNote{note: 49, inst: 1, vol: 0x30, fx: 0x01, param: 0x04, filled: true}
+	song.patterns[0][1][1] = fx(0x0A, 0x20)
+	s := newTestStream(t, song, LoadModuleConfig{})
+
+	// Row 0 and the first tick of row 1.
+	readTicks(t, s, 7)
+
+	want := [][]EffectInfo{
+		{{Name: "SetVolume", Value: 0x20}, {Name: "PortamentoUp", Value: 0x04}},
+		{{Name: "VolumeSlide", Value: 0x20}},
+		nil,
+	}
+	for channel := range want {
+		have := s.ChannelEffects(channel)
+		if !reflect.DeepEqual(have, want[channel]) {
+			t.Errorf("channel %d effects:\nhave: %+v\nwant: %+v", channel, have, want[channel])
+		}
+	}
+	if s.ChannelEffects(3) != nil {
+		t.Error("expected nil effects for the out of range channel")
+	}
+}
+
+func TestSampleOffset16bit(t *testing.T) {
+	render := func(inst testInstrument) []byte {
+		song := newTestSong(1, 4)
+		song.instruments = []testInstrument{inst}
+		song.patterns[0][0][0] = testNote{note: 49, inst: 1, fx: 0x09, param: 0x01, filled: true}
+		s := newTestStream(t, song, LoadModuleConfig{})
+		return readAll(t, s)
+	}
+
+	// The 9xx offset is specified in sample frames for the 16-bit samples too.
+	// The sample is not looped, so the playback ends earlier
+	// if the offset is interpreted differently.
+	inst8 := sineInstrument(16)
+	inst8.loopType = 0
+	inst16 := inst8
+	inst16.data16 = make([]int16, len(inst8.data))
+	for i, v := range inst8.data {
+		inst16.data16[i] = int16(v) << 8
+	}
+
+	want := render(inst8)
+	if peakLevel(want) == 0 {
+		t.Fatal("the note is silent")
+	}
+	if !bytes.Equal(render(inst16), want) {
+		t.Fatal("the 16-bit sample offset doesn't match the 8-bit one")
+	}
+}
+
+func TestNoteCacheOutput(t *testing.T) {
+	// A decaying noise drum hit.
+	drum := testInstrument{name: "drum", volume: 64, panning: 128}
+	rng := uint32(1)
+	for i := 0; i < 3000; i++ {
+		rng = rng*1664525 + 1013904223
+		v := int(int8(rng >> 24))
+		drum.data = append(drum.data, int8(v*(3000-i)/3000/2))
+	}
+
+	song := newTestSong(1, 16)
+	song.instruments = []testInstrument{drum}
+	for row := 0; row < 16; row += 2 {
+		song.patterns[0][row][0] = n(49, 1)
+	}
+	// The pitch changes in the middle of the note,
+	// the channel has to leave the cache and continue from the same position.
+	song.patterns[0][8][0] = testNote{note: 49, inst: 1, fx: 0x01, param: 0x08, filled: true}
+	song.patterns[0][12][0] = testNote{note: 49, inst: 1, fx: 0x00, param: 0x37, filled: true}
+
+	modes := []InterpolationMode{InterpolationLinear, InterpolationNone, InterpolationCubic, InterpolationSinc}
+	for _, interpolation := range modes {
+		config := LoadModuleConfig{Interpolation: interpolation}
+		s := newTestStream(t, song, config)
+		uncached := readAll(t, s)
+
+		s.Rewind()
+		s.SetNoteCaching(true)
+		tick := readTicks(t, s, 1)
+		if s.channels[0].cached == nil {
+			t.Fatalf("interpolation=%v: the note is not cached", interpolation)
+		}
+		cached := append(tick, readAll(t, s)...)
+		if !bytes.Equal(cached, uncached) {
+			t.Fatalf("interpolation=%v: cached output doesn't match the uncached one", interpolation)
+		}
+
+		// Disabling the cache in the middle of a note.
+		s.Rewind()
+		s.SetNoteCaching(true)
+		half := readTicks(t, s, 8*6+1)
+		s.SetNoteCaching(false)
+		rest := readAll(t, s)
+		if !bytes.Equal(append(half, rest...), uncached) {
+			t.Fatalf("interpolation=%v: disabling the cache changed the output", interpolation)
+		}
+	}
+}
+
+func TestNoteDelay(t *testing.T) {
+	song := newTestSong(1, 4)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][1][0] = testNote{note: 61, inst: 1, fx: 0x0E, param: 0xD3, filled: true}
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	freqs := readTickFrequencies(t, s, 0, 12)
+	base := freqs[0]
+
+	// The first row ticks and the row 1 ticks before the delay tick.
+	for tick := 0; tick < 6+3; tick++ {
+		if freqs[tick] != base {
+			t.Fatalf("tick %d: the frequency changed before the delay tick: %v", tick, freqs)
+		}
+	}
+	// The note is one octave higher.
+	for tick := 6 + 3; tick < len(freqs); tick++ {
+		if math.Abs(freqs[tick]-2*base) > 0.5 {
+			t.Fatalf("tick %d: the delayed note is not playing: %v", tick, freqs)
+		}
+	}
+}
+
+func TestChannelAuxSend(t *testing.T) {
+	hit := sineInstrument(2)
+	hit.loopType = 0
+	for channel := 0; channel < 2; channel++ {
+		song := newTestSong(2, 8)
+		song.instruments = []testInstrument{hit}
+		song.patterns[0][0][channel] = n(49, 1)
+
+		s := newTestStream(t, song, LoadModuleConfig{})
+		s.SetChannelAuxSend(0, 1)
+		rows := readRows(t, s, 8, 6)
+		if peakLevel(rows[0]) == 0 {
+			t.Fatalf("channel=%d: the note is silent", channel)
+		}
+		// The note is over after the first row; only the echo tail can be heard.
+		tail := false
+		for _, row := range rows[1:] {
+			if peakLevel(row) != 0 {
+				tail = true
+				break
+			}
+		}
+		wantTail := channel == 0
+		if tail != wantTail {
+			t.Fatalf("channel=%d: tail=%v, want %v", channel, tail, wantTail)
+		}
+	}
+}
+
+func TestChannelFrequencies(t *testing.T) {
+	song := newTestSong(2, 8)
+	song.patterns[0][0][0] = n(58, 1) // A-4
+	for row := 2; row < 8; row++ {
+		song.patterns[0][row][0] = fx(0x04, 0x48)
+	}
+	s := newTestStream(t, song, LoadModuleConfig{})
+	freqs := readTickFrequencies(t, s, 0, 8*6)
+
+	// The sine sample period is 32 frames, so the tone frequency
+	// is 32 times lower than the sample playback rate.
+	const samplePeriod = 32
+	tone := freqs[0] / samplePeriod
+	if math.Abs(tone-440) > 1 {
+		t.Fatalf("A-4 tone frequency is %.2f Hz, want ~440 Hz", tone)
+	}
+	if idle := s.ChannelFrequencies()[1]; idle != 0 {
+		t.Fatalf("the idle channel frequency is %v, want 0", idle)
+	}
+
+	minFreq, maxFreq := freqs[0], freqs[0]
+	numChanges := 0
+	for i := 2 * 6; i < len(freqs); i++ {
+		minFreq = math.Min(minFreq, freqs[i])
+		maxFreq = math.Max(maxFreq, freqs[i])
+		if freqs[i] != freqs[i-1] {
+			numChanges++
+		}
+	}
+	if !(minFreq < freqs[0] && maxFreq > freqs[0]) {
+		t.Fatalf("the vibrato frequency should oscillate around %.2f Hz, got [%.2f, %.2f]",
+			freqs[0], minFreq, maxFreq)
+	}
+	if numChanges < 10 {
+		t.Fatalf("the vibrato frequency changed only %d times", numChanges)
+	}
+}
+
+func TestWrapAround(t *testing.T) {
+	// The note starts in the last order entry and it keeps playing
+	// after the wrap to the first one.
+	song := newTestSong(1, 4, 4)
+	song.patterns[1][0][0] = n(49, 1)
+	song.patterns[1][2][0] = fx(0x04, 0x44) // A vibrato, so the channel state matters
+
+	const patternTicks = 4 * 6
+	s := newTestStream(t, song, LoadModuleConfig{WrapAround: true})
+	wrapped := readTicks(t, s, 3*patternTicks)
+	if s.patternIndex != 0 {
+		t.Fatalf("order after the wrap is %d, want 0", s.patternIndex)
+	}
+
+	// The same patterns in the swapped order without the wrapping.
+	song.order = []byte{1, 0}
+	want := readAll(t, newTestStream(t, song, LoadModuleConfig{}))
+	patternBytes := len(wrapped) / 3
+	if len(want) != 2*patternBytes {
+		t.Fatalf("reference render is %d bytes, want %d", len(want), 2*patternBytes)
+	}
+
+	have := wrapped[patternBytes:]
+	if !bytes.Equal(have, want) {
+		t.Fatal("the output is not continuous across the wrap")
+	}
+	if peakLevel(have[patternBytes:]) == 0 {
+		t.Fatal("the note stopped after the wrap")
+	}
+}
+
+func TestNoteDelayEdgeCases(t *testing.T) {
+	song := newTestSong(1, 4)
+	song.patterns[0][0][0] = n(49, 1)
+	// ED0 is ignored: the note is played right away.
+	song.patterns[0][1][0] = testNote{note: 61, inst: 1, fx: 0x0E, param: 0xD0, filled: true}
+	// The delay is longer than the row (tempo=6): the note is never played.
+	song.patterns[0][2][0] = testNote{note: 73, inst: 1, fx: 0x0E, param: 0xD7, filled: true}
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	freqs := readTickFrequencies(t, s, 0, 4*6)
+	base := freqs[0]
+	for tick := 6; tick < len(freqs); tick++ {
+		if math.Abs(freqs[tick]-2*base) > 0.5 {
+			t.Fatalf("tick %d: frequency is %.2f, want %.2f: %v", tick, freqs[tick], 2*base, freqs)
+		}
+	}
+}
+
+func TestDelayedPortamento(t *testing.T) {
+	song := newTestSong(1, 4)
+	song.patterns[0][0][0] = n(49, 1)
+	// A delayed (ED3) note with the tone portamento in the volume column (F8).
+	song.patterns[0][1][0] = testNote{note: 61, vol: 0xF8, fx: 0x0E, param: 0xD3, filled: true}
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	freqs := readTickFrequencies(t, s, 0, 12)
+	base := freqs[0]
+	target := 2 * base // The portamento target is one octave higher
+
+	// The first row ticks and the row 1 ticks up to the delay tick.
+	for tick := 0; tick <= 6+3; tick++ {
+		if freqs[tick] != base {
+			t.Fatalf("tick %d: the frequency changed before the delay tick: %v", tick, freqs)
+		}
+	}
+	if !(freqs[10] > base && freqs[10] < target) {
+		t.Fatalf("tick 10: the portamento didn't start right after the delay tick: %v", freqs)
+	}
+	if math.Abs(freqs[11]-target) > 0.5 {
+		t.Fatalf("tick 11: the portamento didn't reach the target: %v", freqs)
+	}
+}
+
+func TestLoopRestartPosition(t *testing.T) {
+	song := newTestSong(2, 8, 8)
+	song.restart = 1
+	song.patterns[0][0][0] = testNote{note: 49, inst: 1, fx: 0x0F, param: 3, filled: true}
+	song.patterns[0][0][1] = fx(0x0F, 80)
+	song.patterns[0][1][1] = fx(0x10, 0x20) // Gxx: global volume
+	song.patterns[1][2][0] = n(61, 1)
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	once := readAll(t, s)
+
+	if err := s.SeekTo(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	restartOffset, _ := s.Seek(0, io.SeekCurrent)
+
+	s.Rewind()
+	s.SetLoopCount(1)
+	looped := readAll(t, s)
+
+	want := once[restartOffset:]
+	if len(looped) != len(once)+len(want) {
+		t.Fatalf("looped song length is %d, want %d", len(looped), len(once)+len(want))
+	}
+	if !bytes.Equal(looped[len(once):], want) {
+		t.Fatal("the second iteration doesn't match the first playback of the restart position")
+	}
+
+	// The playback position goes back to the restart position offset.
+	s.Rewind()
+	s.SetLoopCount(-1)
+	buf := make([]byte, len(once)+64)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	pos, _ := s.Seek(0, io.SeekCurrent)
+	if pos != restartOffset+64 {
+		t.Fatalf("position after the restart is %d, want %d", pos, restartOffset+64)
+	}
+}
+
+func TestTimeScaleTickClamping(t *testing.T) {
+	song := newTestSong(1, 8)
+	song.bpm = 255
+	song.patterns[0][0][0] = n(49, 1)
+	s := newTestStream(t, song, LoadModuleConfig{SampleRate: 8000})
+	// 255*4 BPM would make the ticks shorter than numRampPoints frames.
+	s.SetTimeScale(4)
+
+	const numRows = 4
+	buf := make([]byte, numRows*6*numRampPoints*4)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	if s.framesPerTick != numRampPoints {
+		t.Fatalf("frames per tick is %d, want %d", s.framesPerTick, numRampPoints)
+	}
+	// The rows timing should match the rendered (clamped) ticks.
+	renderedSeconds := float64(len(buf)/4) / 8000
+	if math.Abs(s.t-renderedSeconds) > 1e-9 {
+		t.Fatalf("the stream time is %v, the rendered audio is %v seconds long", s.t, renderedSeconds)
+	}
+}
+
+func TestReadAllocs(t *testing.T) {
+	song := newTestSong(2, 4)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][0][1] = n(61, 1)
+	song.patterns[0][1][0] = fx(0x0F, 0x1F) // The slowest tempo
+	song.patterns[0][2][0] = fx(0x0F, 0x20) // The slowest BPM
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetLooping(true)
+	s.SetDelay(DelayConfig{Rows: 1, Ticks: 3, Feedback: 0.5, Mix: 0.5})
+	s.SetChannelAuxSend(1, 0.5)
+	s.SetAuxEffect(AuxReverb)
+	s.SetChannelFilter(0, FilterLowPass, 2000, 0.5)
+
+	buf := make([]byte, 16*1024)
+	// The control methods are applied during the first Read call.
+	if _, err := s.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	delayCapacity := len(s.delay.buf)
+
+	// Every run plays the whole song, so the tempo and BPM slowdowns are measured.
+	allocs := testing.AllocsPerRun(5, func() {
+		loopsDone := s.LoopsDone()
+		for s.LoopsDone() == loopsDone {
+			if _, err := s.Read(buf); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("Read allocates %v times per song iteration", allocs)
+	}
+	// AllocsPerRun has a warm-up run that is not measured,
+	// make sure that the delay buffer was reserved before the playback.
+	if len(s.delay.buf) != delayCapacity {
+		t.Fatalf("the delay buffer has grown from %d to %d during the playback", delayCapacity, len(s.delay.buf))
+	}
+}
+
+func TestUnloadEOF(t *testing.T) {
+	song := newTestSong(1, 4)
+	song.patterns[0][0][0] = n(49, 1)
+	buf := make([]byte, 4096)
+
+	for _, loopCount := range []int{-1, 2} {
+		s := newTestStream(t, song, LoadModuleConfig{})
+		s.SetLoopCount(loopCount)
+		s.OnEnd(func(bool) {
+			t.Fatalf("loopCount=%d: OnEnd is called for an unloaded stream", loopCount)
+		})
+		s.Unload()
+		for i := 0; i < 2; i++ {
+			if n, err := s.Read(buf); n != 0 || err != io.EOF {
+				t.Fatalf("loopCount=%d: Read() = %d, %v; want 0, io.EOF", loopCount, n, err)
+			}
+		}
+	}
+
+	// The Unload is called during the Read call, so it's applied
+	// at the next tick boundary.
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetLoopCount(-1)
+	s.OnRow(func(order, pattern, row int) {
+		if row == 1 {
+			s.Unload()
+		}
+	})
+	s.OnEnd(func(bool) {
+		t.Fatal("OnEnd is called for an unloaded stream")
+	})
+	rowBytes := 6 * s.framesPerTick * s.module.frameSize
+	data := readAll(t, s)
+	if len(data) < rowBytes || len(data) > 2*rowBytes {
+		t.Fatalf("read %d bytes after the Unload, want [%d, %d]", len(data), rowBytes, 2*rowBytes)
+	}
+}
+
+func TestKeyOffEvent(t *testing.T) {
+	const rowSeconds = 0.12 // Tempo=6, BPM=125
+
+	song := newTestSong(1, 8)
+	song.instruments = append(song.instruments, sineInstrument(1))
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][2][0] = testNote{note: 97, filled: true} // A key-off note
+	song.patterns[0][4][0] = n(61, 2)
+	song.patterns[0][6][0] = fx(0x14, 0) // K00: a key-off effect
+
+	type keyOff struct {
+		note, instrument int
+		row              int
+	}
+	var events []keyOff
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetEventHandler(func(e StreamEvent) {
+		if e.Kind == EventKeyOff {
+			note, instrument := e.KeyOffEventData()
+			row := int(math.Round(e.Time / rowSeconds))
+			events = append(events, keyOff{note: note, instrument: instrument, row: row})
+		}
+	})
+	readAll(t, s)
+
+	want := []keyOff{
+		{note: 49, instrument: 0, row: 2},
+		{note: 61, instrument: 1, row: 6},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("key-off events:\nhave: %+v\nwant: %+v", events, want)
+	}
+}
+
+func TestChannelLevelsRampFrames(t *testing.T) {
+	// The sample ends during the note attack ramp frames.
+	click := testInstrument{volume: 64, panning: 128, data: []int8{127, 127, 127, 127}}
+	song := newTestSong(1, 4)
+	song.instruments = []testInstrument{click}
+	song.patterns[0][0][0] = n(49, 1)
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetLevelMetering(true)
+	readTicks(t, s, 1)
+	levels := s.GetLevels()
+	master := math.Max(levels.Left.Peak, levels.Right.Peak)
+	if master == 0 {
+		t.Fatal("the note is not audible")
+	}
+	if levels.Channels[0].Peak != master {
+		t.Fatalf("channel peak is %v, the output peak is %v", levels.Channels[0].Peak, master)
+	}
+}
+
+func TestReadSmallBuffers(t *testing.T) {
+	song := newTestSong(2, 8)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][3][1] = testNote{note: 56, inst: 1, fx: 0x0F, param: 97, filled: true}
+	song.patterns[0][5][0] = fx(0x0F, 3)
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	var ticks []byte
+	for {
+		// The BPM changes, so the tick size is re-calculated for every tick.
+		tick := make([]byte, s.framesPerTick*s.module.frameSize)
+		n, err := s.Read(tick)
+		ticks = append(ticks, tick[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An odd size that doesn't divide the frames or the ticks.
+	s.Rewind()
+	var small []byte
+	buf := make([]byte, 7)
+	for {
+		n, err := s.Read(buf)
+		small = append(small, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(small, ticks) {
+		t.Fatalf("7-byte reads output (%d bytes) doesn't match the tick reads (%d bytes)", len(small), len(ticks))
+	}
+}
+
+// newSeekTestStream returns a stream of a song that changes its state
+// over time (notes, effects and BPM changes) along with its full render.
+func newSeekTestStream(t *testing.T) (*Stream, []byte) {
+	t.Helper()
+	song := newTestSong(2, 8, 8)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][2][1] = testNote{note: 56, inst: 1, fx: 0x0A, param: 0x04, filled: true}
+	song.patterns[0][3][0] = fx(0x0F, 97)
+	song.patterns[1][1][0] = testNote{note: 61, inst: 1, fx: 0x04, param: 0x48, filled: true}
+	song.patterns[1][5][1] = fx(0x0F, 4)
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	full := readAll(t, s)
+	return s, full
+}
+
+// checkSeekTail checks that the stream continues from the offset
+// exactly like the full render does.
+func checkSeekTail(t *testing.T, s *Stream, full []byte, name string, offset int) {
+	t.Helper()
+	pos, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(pos) != offset {
+		t.Fatalf("%s: the position is %d, want %d", name, pos, offset)
+	}
+	if have := readAll(t, s); !bytes.Equal(have, full[offset:]) {
+		t.Fatalf("%s: the output after the seek doesn't match the full render (%d vs %d bytes)",
+			name, len(have), len(full)-offset)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	s, full := newSeekTestStream(t)
+
+	for _, offset := range []int64{4, 4 * 1000, 4*5555 + 2, int64(len(full)) - 4*300} {
+		if _, err := s.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		checkSeekTail(t, s, full, "Seek", int(offset)/4*4)
+	}
+
+	s.Rewind()
+	readTicks(t, s, 3)
+	if _, err := s.Seek(4*2000, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	checkSeekTail(t, s, full, "Seek(SeekCurrent)", 3*s.module.framesPerTick*4+4*2000)
+
+	if _, err := s.Seek(-4*700, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	checkSeekTail(t, s, full, "Seek(SeekEnd)", len(full)-4*700)
+	if pos, err := s.Seek(0, io.SeekEnd); err != nil || int(pos) != len(full) {
+		t.Fatalf("Seek(0, SeekEnd) = %d, %v; want %d", pos, err, len(full))
+	}
+	if n, err := s.Read(make([]byte, 64)); n != 0 || err != io.EOF {
+		t.Fatalf("Read() after Seek(0, SeekEnd) = %d, %v; want 0, io.EOF", n, err)
+	}
+}
+
+func TestSeekDuration(t *testing.T) {
+	s, full := newSeekTestStream(t)
+
+	durations := []time.Duration{
+		time.Millisecond,
+		1234567 * time.Microsecond,
+		s.Duration() - 10*time.Millisecond,
+	}
+	for _, d := range durations {
+		if err := s.SeekDuration(d); err != nil {
+			t.Fatal(err)
+		}
+		checkSeekTail(t, s, full, fmt.Sprintf("SeekDuration(%v)", d), s.durationToFrames(d)*4)
+	}
+
+	if err := s.SeekDuration(s.Duration() + time.Second); err == nil {
+		t.Fatal("SeekDuration: expected an error for an offset beyond the song end")
+	}
+	checkSeekTail(t, s, full, "SeekDuration beyond the end", 0)
+}
+
+func TestSeekTo(t *testing.T) {
+	s, _ := newSeekTestStream(t)
+
+	// The row handler is called before the row first tick is rendered.
+	type rowKey struct{ order, row int }
+	rowOffsets := map[rowKey]int{}
+	s.Rewind()
+	s.OnRow(func(order, pattern, row int) {
+		rowOffsets[rowKey{order, row}] = s.bytePos
+	})
+	full := readAll(t, s)
+	s.OnRow(nil)
+
+	for _, key := range []rowKey{{0, 0}, {0, 4}, {1, 0}, {1, 6}} {
+		if err := s.SeekTo(key.order, key.row); err != nil {
+			t.Fatal(err)
+		}
+		checkSeekTail(t, s, full, fmt.Sprintf("SeekTo(%d, %d)", key.order, key.row), rowOffsets[key])
+	}
+
+	if err := s.SeekTo(2, 0); err == nil {
+		t.Fatal("SeekTo: expected an error for the out of range order")
+	}
+	if err := s.SeekTo(0, 8); err == nil {
+		t.Fatal("SeekTo: expected an error for the out of range row")
+	}
+}
+
+func TestSeekToUnreachableRow(t *testing.T) {
+	song := newTestSong(1, 8, 8)
+	song.patterns[0][1][0] = fx(0x0D, 0) // The rest of the first pattern is skipped
+	song.patterns[0][5][0] = n(49, 1)
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	if err := s.SeekTo(0, 5); err != nil {
+		t.Fatal(err)
+	}
+	if pos, _ := s.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Fatalf("the position is %d, want 0", pos)
+	}
+	rowBytes := 6 * s.framesPerTick * s.module.frameSize
+	// The playback starts from the row 5: the note is played right away
+	// and the song ends after the first pattern rest and the second pattern.
+	data := readAll(t, s)
+	if peakLevel(data[:rowBytes]) == 0 {
+		t.Fatal("the row 5 note is not played")
+	}
+	if len(data) != (3+8)*rowBytes {
+		t.Fatalf("rendered %d bytes, want %d", len(data), (3+8)*rowBytes)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	song := newTestSong(1, 8, 8)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[1][2][0] = testNote{note: 61, inst: 1, fx: 0x0F, param: 90, filled: true}
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetLoopCount(1)
+	s.SetFadeOut(500 * time.Millisecond)
+	want := readAll(t, s)
+
+	s.Rewind()
+	var b bytes.Buffer
+	n, err := s.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != b.Len() {
+		t.Fatalf("WriteTo reported %d bytes, %d were written", n, b.Len())
+	}
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Fatalf("WriteTo output (%d bytes) doesn't match the Read output (%d bytes)", b.Len(), len(want))
+	}
+	if n, err := s.Read(make([]byte, 64)); n != 0 || err != io.EOF {
+		t.Fatalf("Read() after WriteTo = %d, %v; want 0, io.EOF", n, err)
+	}
+
+	// The endless loops are cut right before their second iteration.
+	song.patterns[1][7][0] = fx(0x0B, 0)
+	s = newTestStream(t, song, LoadModuleConfig{})
+	b.Reset()
+	if _, err := s.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := s.durationToFrames(s.Duration()) * 4; b.Len() != want {
+		t.Fatalf("the endless song render is %d bytes, want %d", b.Len(), want)
+	}
+}
+
+func TestLoopCount(t *testing.T) {
+	song := newTestSong(1, 8)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][4][0] = fx(0x0F, 90) // The loop restores the initial BPM
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	once := readAll(t, s)
+
+	for _, loops := range []int{1, 3} {
+		s.Rewind()
+		s.SetLoopCount(loops)
+		have := readAll(t, s)
+		want := bytes.Repeat(once, loops+1)
+		if !bytes.Equal(have, want) {
+			t.Fatalf("loops=%d: the output (%d bytes) is not %d song renders (%d bytes)",
+				loops, len(have), loops+1, len(want))
+		}
+		if s.LoopsDone() != loops {
+			t.Fatalf("loops=%d: LoopsDone() = %d", loops, s.LoopsDone())
+		}
+	}
+}
+
+func TestFadeOut(t *testing.T) {
+	song := newTestSong(1, 16)
+	song.patterns[0][0][0] = n(49, 1)
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetLoopCount(1)
+	looped := pcmFrames(readAll(t, s))
+	once := looped[:len(looped)/2]
+
+	const fadeOut = 700 * time.Millisecond
+	fadeFrames := int(fadeOut.Seconds() * 44100)
+	s.Rewind()
+	s.SetLoopCount(0)
+	s.SetFadeOut(fadeOut)
+	have := pcmFrames(readAll(t, s))
+	faded := have[len(once):]
+
+	// The output is cut at the tick boundary, the frames
+	// after the fade-out end are silent.
+	tickFrames := s.module.framesPerTick
+	if len(faded) < fadeFrames || len(faded) >= fadeFrames+tickFrames {
+		t.Fatalf("the fade-out is %d frames long, want %d (rounded up to a tick)", len(faded), fadeFrames)
+	}
+	for i, frame := range faded[:fadeFrames] {
+		k := float64(fadeFrames-i) / float64(fadeFrames)
+		for side, v := range frame {
+			want := float64(once[i][side]) * k
+			if math.Abs(float64(v)-want) > 1 {
+				t.Fatalf("fade-out frame %d[%d] is %d, want %.1f", i, side, v, want)
+			}
+		}
+	}
+	for i, frame := range faded[fadeFrames:] {
+		if frame != [2]int16{} {
+			t.Fatalf("frame %d after the fade-out end is not silent: %v", i, frame)
+		}
+	}
+}
+
+func TestRowAndPatternHandlers(t *testing.T) {
+	song := newTestSong(1, 3, 3, 3)
+	song.patterns[0][1][0] = fx(0x0B, 2) // Jump to the order 2
+	song.patterns[2][0][0] = fx(0x0E, 0x60)
+	song.patterns[2][1][0] = fx(0x0E, 0x61) // Loop over the first two rows once
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	var events []string
+	s.OnPattern(func(order, pattern int) {
+		events = append(events, fmt.Sprintf("pattern %d:%d", order, pattern))
+	})
+	s.OnRow(func(order, pattern, row int) {
+		events = append(events, fmt.Sprintf("row %d:%d:%d", order, pattern, row))
+	})
+	readAll(t, s)
+
+	want := []string{
+		"pattern 0:0",
+		"row 0:0:0",
+		"row 0:0:1",
+		"pattern 2:2",
+		"row 2:2:0",
+		"row 2:2:1",
+		"row 2:2:0",
+		"row 2:2:1",
+		"row 2:2:2",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("handler calls:\nhave: %q\nwant: %q", events, want)
+	}
+}
+
+func TestEndHandler(t *testing.T) {
+	song := newTestSong(1, 4, 4)
+	song.patterns[0][1][0] = fx(0x0B, 1) // A forward jump is not the song end
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	var events []string
+	s.OnPattern(func(order, pattern int) {
+		events = append(events, fmt.Sprintf("pattern %d", order))
+	})
+	s.OnEnd(func(looping bool) {
+		events = append(events, fmt.Sprintf("end looping=%v", looping))
+	})
+	s.SetLoopCount(1)
+	readAll(t, s)
+	// The stream is over, the handler is not called again.
+	readAll(t, s)
+
+	want := []string{
+		"pattern 0",
+		"pattern 1",
+		"end looping=true",
+		"pattern 0",
+		"pattern 1",
+		"end looping=false",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("handler calls:\nhave: %q\nwant: %q", events, want)
+	}
+}
+
+func TestChannelMuteAndSolo(t *testing.T) {
+	newSong := func(channels ...int) *testSong {
+		song := newTestSong(3, 8)
+		song.instruments = append(song.instruments, sineInstrument(2))
+		for _, ch := range channels {
+			song.patterns[0][0][ch] = n(byte(49+ch*5), byte(1+ch%2))
+			song.patterns[0][4][ch] = testNote{note: byte(56 + ch), inst: 1, fx: 0x08, param: byte(ch * 100), filled: true}
+		}
+		return song
+	}
+	render := func(song *testSong, setup func(s *Stream)) []byte {
+		s := newTestStream(t, song, LoadModuleConfig{})
+		setup(s)
+		return readAll(t, s)
+	}
+	full := newSong(0, 1, 2)
+
+	muted := render(full, func(s *Stream) { s.SetChannelMuted(1, true) })
+	if want := render(newSong(0, 2), func(*Stream) {}); !bytes.Equal(muted, want) {
+		t.Fatal("a muted channel output doesn't match the song without that channel")
+	}
+
+	solo := render(full, func(s *Stream) {
+		s.SetChannelSolo(0, true)
+		s.SetChannelSolo(2, true)
+	})
+	if want := render(newSong(0, 2), func(*Stream) {}); !bytes.Equal(solo, want) {
+		t.Fatal("the solo channels output doesn't match the song with only these channels")
+	}
+
+	mutedSolo := render(full, func(s *Stream) {
+		s.SetChannelSolo(1, true)
+		s.SetChannelMuted(1, true)
+	})
+	if peakLevel(mutedSolo) != 0 {
+		t.Fatal("a muted solo channel is audible")
+	}
+
+	// Unmuting resumes the channel as if it was never muted.
+	s := newTestStream(t, full, LoadModuleConfig{})
+	want := readAll(t, s)
+	s.Rewind()
+	s.SetChannelMuted(1, true)
+	head := readTicks(t, s, 12)
+	s.SetChannelMuted(1, false)
+	have := append(head, readAll(t, s)...)
+	// The volume ramp takes a few frames after the unmute.
+	const rampBytes = 4 * 256
+	tail := len(head) + rampBytes
+	if !bytes.Equal(have[tail:], want[tail:]) {
+		t.Fatal("the unmuted channel doesn't continue from the same position")
+	}
+}
+
+func TestChannelVolume(t *testing.T) {
+	newSong := func(channels ...int) *testSong {
+		song := newTestSong(2, 8)
+		for _, ch := range channels {
+			song.patterns[0][0][ch] = n(byte(49+ch*7), 1)
+		}
+		return song
+	}
+
+	s := newTestStream(t, newSong(0, 1), LoadModuleConfig{})
+	s.SetChannelVolume(1, 0)
+	silenced := readAll(t, s)
+	s = newTestStream(t, newSong(0), LoadModuleConfig{})
+	want := readAll(t, s)
+	if !bytes.Equal(silenced, want) {
+		t.Fatal("a zero volume channel is audible")
+	}
+
+	s = newTestStream(t, newSong(0), LoadModuleConfig{})
+	s.SetChannelVolume(0, 0.5)
+	half := pcmFrames(readAll(t, s))
+	// The volume ramp speed doesn't depend on the volume,
+	// so the attack frames are not compared.
+	const rampFrames = 256
+	for i, frame := range pcmFrames(want) {
+		if i < rampFrames {
+			continue
+		}
+		for side, v := range frame {
+			if math.Abs(float64(half[i][side])-float64(v)/2) > 1 {
+				t.Fatalf("frame %d[%d] is %d, want %v", i, side, half[i][side], float64(v)/2)
+			}
+		}
+	}
+}
+
+func TestVolumeRamping(t *testing.T) {
+	// A looped DC sample makes the output follow the channel volume.
+	dc := testInstrument{volume: 64, panning: 128, loopType: 1, loopLength: 16}
+	for i := 0; i < 16; i++ {
+		dc.data = append(dc.data, 64)
+	}
+	song := newTestSong(1, 4)
+	song.instruments = []testInstrument{dc}
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][2][0] = fx(0x0C, 0) // An abrupt volume change
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	// A louder channel makes the ramp longer.
+	s.SetReferenceMixing(true)
+	rows := readRows(t, s, 3, 6)
+	before := pcmFrames(rows[1])
+	after := pcmFrames(rows[2])
+	level := int(before[len(before)-1][0])
+	if level == 0 {
+		t.Fatal("the note is not audible")
+	}
+
+	// The volume goes down frame by frame until it reaches 0,
+	// there are no jumps that would produce a click.
+	prev := level
+	settled := -1
+	for i, frame := range after {
+		v := int(frame[0])
+		if v > prev || prev-v > level/64 {
+			t.Fatalf("frame %d: the level changed from %d to %d", i, prev, v)
+		}
+		if v == 0 && settled == -1 {
+			settled = i
+		}
+		prev = v
+	}
+	// The ramp is longer than the ramp of the note start (numRampPoints frames),
+	// but it settles within a single tick.
+	if settled <= numRampPoints || settled >= s.framesPerTick {
+		t.Fatalf("the volume settled after %d frames, want (%d, %d)", settled, numRampPoints, s.framesPerTick)
+	}
+}
+
+func TestStereoSeparation(t *testing.T) {
+	song := newTestSong(2, 8)
+	song.patterns[0][0][0] = testNote{note: 49, inst: 1, fx: 0x08, param: 0x00, filled: true}
+	song.patterns[0][0][1] = testNote{note: 56, inst: 1, fx: 0x08, param: 0xC0, filled: true}
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetStereoSeparation(0)
+	data := readAll(t, s)
+	if peakLevel(data) == 0 {
+		t.Fatal("the song is silent")
+	}
+	for i, frame := range pcmFrames(data) {
+		if frame[0] != frame[1] {
+			t.Fatalf("frame %d: the mono output has different channels: %v", i, frame)
+		}
+	}
+}
+
+func TestAmigaPanning(t *testing.T) {
+	song := newTestSong(4, 8)
+	for ch := 0; ch < 4; ch++ {
+		// The module panning is ignored.
+		song.patterns[0][0][ch] = testNote{note: 49, inst: 1, fx: 0x08, param: 0x80, filled: true}
+	}
+
+	// The channels are panned hard left, right, right, left.
+	wantLeft := []bool{true, false, false, true}
+	for ch, left := range wantLeft {
+		s := newTestStream(t, song, LoadModuleConfig{AmigaPanning: true})
+		s.SetChannelSolo(ch, true)
+		data := readAll(t, s)
+		if peakLevel(data) == 0 {
+			t.Fatalf("channel %d is silent", ch)
+		}
+		silentSide := 0
+		if left {
+			silentSide = 1
+		}
+		for i, frame := range pcmFrames(data) {
+			if frame[silentSide] != 0 {
+				t.Fatalf("channel %d frame %d: the opposite side is audible: %v", ch, i, frame)
+			}
+		}
+	}
+}
+
+func TestPanningLaw(t *testing.T) {
+	// A looped DC sample makes the output follow the channel gains.
+	dc := testInstrument{volume: 64, loopType: 1, loopLength: 16}
+	for i := 0; i < 16; i++ {
+		dc.data = append(dc.data, 64)
+	}
+	// The full-scale level of the DC sample (with the reference mixing).
+	const level = 64 << 8
+
+	tests := []struct {
+		law         PanningLaw
+		panning     byte
+		left, right float64
+	}{
+		{PanningLawEqualPower, 0x80, level * math.Sqrt2 / 2, level * math.Sqrt2 / 2},
+		{PanningLawEqualPower, 0x00, level, 0},
+		{PanningLawLinear, 0x80, level / 2, level / 2},
+		{PanningLawLinear, 0x40, level * 3 / 4, level / 4},
+		{PanningLawBalance, 0x80, level, level},
+		{PanningLawBalance, 0x40, level, level / 2},
+	}
+	for _, test := range tests {
+		inst := dc
+		inst.panning = test.panning
+		song := newTestSong(1, 4)
+		song.instruments = []testInstrument{inst}
+		song.patterns[0][0][0] = n(49, 1)
+
+		s := newTestStream(t, song, LoadModuleConfig{})
+		s.SetReferenceMixing(true)
+		s.SetPanningLaw(test.law)
+		frames := pcmFrames(readAll(t, s))
+		last := frames[len(frames)-1]
+		if math.Abs(float64(last[0])-test.left) > 1 || math.Abs(float64(last[1])-test.right) > 1 {
+			t.Errorf("law=%d panning=%#x: the levels are %v, want [%.0f %.0f]",
+				test.law, test.panning, last, test.left, test.right)
+		}
+	}
+}
+
+func TestReverbTail(t *testing.T) {
+	song := newTestSong(2, 8)
+	song.patterns[0][0][0] = n(49, 1)
+	song.patterns[0][1][0] = fx(0x0C, 0x00)
+	const tailOffset = 2 * 6 * 882 * 4 // Row 2 (the volume is ramped down by then)
+
+	dry := readAll(t, newTestStream(t, song, LoadModuleConfig{}))
+	if peakLevel(dry[tailOffset:]) != 0 {
+		t.Fatalf("the dry output is not silent after the note is stopped")
+	}
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetAuxEffect(AuxReverb)
+	s.SetChannelAuxSend(0, 1)
+	wet := readAll(t, s)
+	if len(wet) != len(dry) {
+		t.Fatalf("the wet output is %d bytes long, want %d", len(wet), len(dry))
+	}
+	if bytes.Equal(wet[:tailOffset], dry[:tailOffset]) {
+		t.Errorf("the reverb doesn't change the note")
+	}
+	if peakLevel(wet[tailOffset:]) == 0 {
+		t.Errorf("the reverb has no tail after the note is stopped")
+	}
+
+	// The aux bus is active, but the note channel doesn't send anything to it.
+	s = newTestStream(t, song, LoadModuleConfig{})
+	s.SetAuxEffect(AuxReverb)
+	s.SetChannelAuxSend(1, 1)
+	if !bytes.Equal(readAll(t, s), dry) {
+		t.Errorf("a zero aux send changes the output")
+	}
+}
+
+func TestDelayTiming(t *testing.T) {
+	click := testInstrument{volume: 64, panning: 128, data: make([]int8, 64)}
+	for i := range click.data {
+		click.data[i] = 100
+	}
+	song := newTestSong(1, 4)
+	song.instruments = []testInstrument{click}
+	song.patterns[0][0][0] = n(49, 1)
+	const rowFrames = 6 * 882
+
+	dry := pcmFrames(readAll(t, newTestStream(t, song, LoadModuleConfig{})))
+	for i := rowFrames / 2; i < len(dry); i++ {
+		if dry[i] != [2]int16{} {
+			t.Fatalf("frame %d: the click is too long", i)
+		}
+	}
+
+	s := newTestStream(t, song, LoadModuleConfig{})
+	s.SetDelay(DelayConfig{Rows: 1, Mix: 1})
+	wet := pcmFrames(readAll(t, s))
+	if len(wet) != len(dry) {
+		t.Fatalf("the delayed output has %d frames, want %d", len(wet), len(dry))
+	}
+	for i := 0; i < rowFrames; i++ {
+		if wet[i] != dry[i] {
+			t.Fatalf("frame %d: the output before the echo is %v, want %v", i, wet[i], dry[i])
+		}
+	}
+	// The echo is the click repeated exactly one row later.
+	for i := 0; i < rowFrames; i++ {
+		got, want := wet[rowFrames+i], dry[i]
+		if abs(float64(got[0])-float64(want[0])) > 1 || abs(float64(got[1])-float64(want[1])) > 1 {
+			t.Fatalf("echo frame %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestLowPassFilter(t *testing.T) {
+	// The sine instrument plays ~2 kHz at C-7 and ~260 Hz at C-4.
+	tests := []struct {
+		note   byte
+		cutoff float64
+		minDB  float64
+		maxDB  float64
+	}{
+		{note: 85, cutoff: 200, minDB: -60, maxDB: -20},
+		{note: 49, cutoff: 5000, minDB: -1, maxDB: 1},
+	}
+	for _, test := range tests {
+		song := newTestSong(1, 8)
+		song.patterns[0][0][0] = n(test.note, 1)
+		const skip = 6 * 882 * 4 // The first row has the filter transients
+
+		dry := readAll(t, newTestStream(t, song, LoadModuleConfig{}))
+		s := newTestStream(t, song, LoadModuleConfig{})
+		s.SetChannelFilter(0, FilterLowPass, test.cutoff, 0)
+		filtered := readAll(t, s)
+
+		db := 20 * math.Log10(float64(peakLevel(filtered[skip:]))/float64(peakLevel(dry[skip:])))
+		if db < test.minDB || db > test.maxDB {
+			t.Errorf("note=%d cutoff=%v: the level change is %.1f dB, want [%v, %v]",
+				test.note, test.cutoff, db, test.minDB, test.maxDB)
+		}
+	}
+}
@@ -5,7 +5,7 @@ import (
 )
 
 type numeric interface {
-	uint8 | int | float64
+	uint8 | int | uint64 | float64
 }
 
 func slideTowards[T numeric](v, goal, delta T) T {
@@ -53,23 +53,88 @@ func abs(x float64) float64 {
 	return x
 }
 
+// xmVolume converts an XM volume value in [0, 64] range into [0, 1] range.
+// Out of range values are clamped, like FastTracker II does.
+// Both sample volumes and the set volume effects use this conversion.
+func xmVolume(v int) float64 {
+	return float64(clamp(v, 0, 64)) / 64
+}
+
+// amigaChannelPanning returns the channel panning of the Amiga LRRL layout.
+// The channels 0 and 3 are played by the left Paula voices, 1 and 2 by the right ones.
+func amigaChannelPanning(channel int) float64 {
+	switch channel % 4 {
+	case 1, 2:
+		return 1
+	default:
+		return 0
+	}
+}
+
 func calcSecondsPerRow(ticksPerRow int, bpm float64) float64 {
 	ticksPerSecond := bpm * 0.4
 	return 1 / (ticksPerSecond / float64(ticksPerRow))
 }
 
-func calcSamplesPerTick(sampleRate, bpm float64) (samplesPerTick float64, bytesPerTick int) {
+func calcSamplesPerTick(sampleRate, bpm float64) (samplesPerTick float64, framesPerTick int) {
 	samplesPerTick = math.Round(sampleRate / (bpm * 0.4))
-	const (
-		channels       = 2
-		bytesPerSample = 2
-	)
-	bytesPerTick = int(samplesPerTick) * channels * bytesPerSample
-	return samplesPerTick, bytesPerTick
+	framesPerTick = int(samplesPerTick)
+	return samplesPerTick, framesPerTick
 }
 
-func waveform(step uint8) float64 {
-	return -math.Sin(2 * 3.141592 * float64(step) / 0x40)
+// waveformKind is an oscillator shape used by the vibrato-like effects.
+type waveformKind uint8
+
+const (
+	waveformSine waveformKind = iota
+	waveformRampDown
+	waveformSquare
+	waveformRandom
+)
+
+// sineTable is a half-period of the FastTracker II vibrato sine wave.
+var sineTable = [32]uint8{
+	0, 24, 49, 74, 97, 120, 141, 161,
+	180, 197, 212, 224, 235, 244, 250, 253,
+	255, 253, 250, 244, 235, 224, 212, 197,
+	180, 161, 141, 120, 97, 74, 49, 24,
+}
+
+// waveform returns the oscillator value in [-255, 255] range.
+//
+// The pos is an oscillator phase, a full period is 256 units long
+// (this is how FastTracker II stores the vibrato position).
+// The random waveform uses the rng state to produce the values.
+func waveform(kind waveformKind, pos uint8, rng *uint32) int {
+	var v int
+	i := (pos >> 2) & 0x1F
+	switch kind {
+	case waveformSine:
+		v = int(sineTable[i])
+	case waveformRampDown:
+		v = int(i) << 3
+		if pos&0x80 != 0 {
+			v = 255 - v
+		}
+	case waveformSquare:
+		v = 255
+	case waveformRandom:
+		// A xorshift generator is good enough for this purpose
+		// and it makes the playback deterministic.
+		x := *rng
+		if x == 0 {
+			x = 0x9E3779B9
+		}
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		*rng = x
+		return int(x%511) - 255
+	}
+	if pos&0x80 != 0 {
+		return -v
+	}
+	return v
 }
 
 func calcRealNote(fnote float64, inst *instrument) float64 {
@@ -82,10 +147,68 @@ func calcRealNote(fnote float64, inst *instrument) float64 {
 	return (fnote + frelativeNote + ffinetune/128) - 1
 }
 
+// calcNotePeriod returns the period of the note played by the given instrument.
+//
+// If the instrument can't play this note, ok=false is returned,
+// unless that instrument is configured to clamp such notes.
+func calcNotePeriod(fnote float64, inst *instrument) (period float64, ok bool) {
+	if inst == nil {
+		return linearPeriod(calcRealNote(fnote, nil)), true
+	}
+
+	// FastTracker II note range is 10 octaves.
+	// The relative note can move the note outside of it.
+	const (
+		minRealNote = 1
+		maxRealNote = 10*12 - 1
+	)
+	realNote := fnote + float64(inst.relativeNote)
+	playable := realNote >= minRealNote && realNote <= maxRealNote && inst.isNoteMapped(fnote)
+	if !playable {
+		if !inst.clampNotes {
+			return 0, false
+		}
+		fnote = clamp(realNote, minRealNote, maxRealNote) - float64(inst.relativeNote)
+	}
+
+	return linearPeriod(calcRealNote(fnote, inst)), true
+}
+
+// minPeriod and maxPeriod define the period values range
+// that can be reached by the period slides.
+// The minPeriod is the highest pitch, the maxPeriod is the lowest pitch.
+const (
+	minPeriod = 1
+	maxPeriod = 32000 - 1
+)
+
 func linearPeriod(note float64) float64 {
 	return 7680.0 - note*64.0
 }
 
+// glissandoPeriod rounds the period to the nearest semitone.
+// The instrument finetune is taken into account,
+// so the rounded period matches the period of some real note.
+func glissandoPeriod(period float64, inst *instrument) float64 {
+	finetuneOffset := 0.0
+	if inst != nil {
+		finetuneOffset = float64(inst.finetune) / 2
+	}
+	return math.Round((period+finetuneOffset)/64)*64 - finetuneOffset
+}
+
+// samplePosFracBits is the number of the fraction bits in the
+// fixed-point sample positions (the 32.32 format).
+// The integer arithmetic makes the sample position updates
+// cheaper than the float64 ones, especially on 32-bit and mobile CPUs.
+const samplePosFracBits = 32
+
+// toSamplePos converts a sample offset into a fixed-point sample position.
+// The offset should be non-negative.
+func toSamplePos(offset float64) uint64 {
+	return uint64(offset * (1 << samplePosFracBits))
+}
+
 func linearFrequency(period float64) float64 {
 	return 8363.0 * math.Pow(2, (4608-period)/768)
 }
@@ -101,10 +224,98 @@ func envelopeLerp(a, b envelopePoint, frame int) float64 {
 	return a.value*(1-p) + b.value*p
 }
 
-func putPCM(buf []byte, left, right uint16) {
+// softClipThreshold is the level (relative to the 16-bit full scale)
+// above which the soft clipping starts to compress the signal.
+const softClipThreshold = 0.8
+
+// softClip applies a soft saturation to the mixed sample values.
+//
+// The values below the threshold are not affected.
+// The louder ones are smoothly compressed towards the full scale
+// (the curve is continuous and it has no sharp knee), so the loud
+// passages are saturated instead of being hard-clipped by the output conversion.
+// The output never exceeds the full scale.
+func softClip(mix []float64) {
+	const (
+		fullScale = 32768.0
+		threshold = softClipThreshold * fullScale
+		headroom  = fullScale - threshold
+	)
+	for i, v := range mix {
+		if v > threshold {
+			mix[i] = threshold + headroom*math.Tanh((v-threshold)/headroom)
+		} else if v < -threshold {
+			mix[i] = -threshold - headroom*math.Tanh((-v-threshold)/headroom)
+		}
+	}
+}
+
+// toInt16 converts a mixed sample value into a signed 16-bit sample.
+// The value is rounded to the nearest integer (halves are rounded away from zero).
+// Out of range values are saturated instead of being wrapped around:
+// a wrap-around turns a loud peak into a sign flip, which produces a loud pop.
+func toInt16(v float64) int16 {
+	if v >= math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v <= math.MinInt16 {
+		return math.MinInt16
+	}
+	if v < 0 {
+		return int16(v - 0.5)
+	}
+	return int16(v + 0.5)
+}
+
+// toInt32 is like toInt16, but it saturates the value to the [min, max] range.
+// It's used for the higher bit depth output formats.
+func toInt32(v float64, min, max int32) int32 {
+	if v >= float64(max) {
+		return max
+	}
+	if v <= float64(min) {
+		return min
+	}
+	if v < 0 {
+		return int32(v - 0.5)
+	}
+	return int32(v + 0.5)
+}
+
+func putPCM(buf []byte, left, right int16) {
 	_ = buf[3] // Early bound check
-	buf[0] = byte(left)
-	buf[1] = byte(left >> 8)
-	buf[2] = byte(right)
-	buf[3] = byte(right >> 8)
+	// The int16->uint16 conversion keeps the two's complement bits intact,
+	// so the negative values are encoded correctly.
+	l := uint16(left)
+	r := uint16(right)
+	buf[0] = byte(l)
+	buf[1] = byte(l >> 8)
+	buf[2] = byte(r)
+	buf[3] = byte(r >> 8)
+}
+
+// growChannelSettings makes sure that a per-channel settings slice
+// can be indexed by any of the numChannels channels.
+// The existing values are preserved.
+func growChannelSettings[T any](values []T, numChannels int) []T {
+	if len(values) >= numChannels {
+		return values
+	}
+	grown := make([]T, numChannels)
+	copy(grown, values)
+	return grown
+}
+
+// reuseSlice returns a zeroed slice of length n.
+// The buf memory is reused if possible.
+func reuseSlice[T any](buf []T, n int) []T {
+	if cap(buf) < n {
+		return make([]T, n)
+	}
+	buf = buf[:n]
+	var zero T
+	for i := range buf {
+		buf[i] = zero
+	}
+	return buf
 }
@@ -0,0 +1,90 @@
+package xm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToInt16(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want int16
+	}{
+		{0, 0},
+		{0.4, 0},
+		{0.5, 1},
+		{-0.5, -1},
+		{1.5, 2},
+		{-1.5, -2},
+		{2.49, 2},
+		{-2.49, -2},
+		{32766.5, 32767},
+		{32767, 32767},
+		{32767.4, 32767},
+		{32768, 32767},
+		{40000, 32767},
+		{-32767.5, -32768},
+		{-32768, -32768},
+		{-32768.6, -32768},
+		{-40000, -32768},
+		{math.Inf(1), 32767},
+		{math.Inf(-1), -32768},
+	}
+	for _, test := range tests {
+		if have := toInt16(test.v); have != test.want {
+			t.Errorf("toInt16(%v) = %d, want %d", test.v, have, test.want)
+		}
+	}
+}
+
+func TestPutPCM(t *testing.T) {
+	values := []int16{0, 1, -1, math.MaxInt16, math.MinInt16, math.MinInt16 + 1}
+	buf := make([]byte, 4)
+	for _, v := range values {
+		putPCM(buf, v, -v)
+		frames := pcmFrames(buf)
+		if frames[0][0] != v || frames[0][1] != -v {
+			t.Errorf("putPCM(%d, %d) is decoded as %v", v, -v, frames[0])
+		}
+	}
+}
+
+func TestSoftClip(t *testing.T) {
+	const (
+		fullScale = 32768.0
+		threshold = softClipThreshold * fullScale
+	)
+
+	// The values below the threshold are not changed at all.
+	quiet := []float64{0, 1, -1, 1000.25, -20000, threshold, -threshold}
+	mix := append([]float64(nil), quiet...)
+	softClip(mix)
+	for i, v := range mix {
+		if v != quiet[i] {
+			t.Errorf("softClip(%v) = %v, want the same value", quiet[i], v)
+		}
+	}
+
+	// The louder values are compressed monotonically
+	// and they never exceed the full scale.
+	prev := threshold
+	for v := threshold + 1; v < 20*fullScale; v *= 1.01 {
+		mix := []float64{v, -v}
+		softClip(mix)
+		if mix[0] > fullScale || mix[0] < prev {
+			t.Fatalf("softClip(%v) = %v, want a value in [%v, %v]", v, mix[0], prev, fullScale)
+		}
+		if mix[1] != -mix[0] {
+			t.Fatalf("softClip(%v) = %v, want %v", -v, mix[1], -mix[0])
+		}
+		if mix[0] >= v {
+			t.Fatalf("softClip(%v) = %v, the value is not compressed", v, mix[0])
+		}
+		prev = mix[0]
+	}
+	mix = []float64{math.Inf(1), math.Inf(-1)}
+	softClip(mix)
+	if mix[0] != fullScale || mix[1] != -fullScale {
+		t.Fatalf("softClip(±Inf) = %v, want ±%v", mix, fullScale)
+	}
+}
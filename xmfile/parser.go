@@ -113,6 +113,15 @@ func (p *parser) errorf(format string, args ...any) *ParseError {
 	return e
 }
 
+// deviation reports a spec violation that can be repaired.
+// In strict mode, it aborts the parsing with a ParseError.
+// Otherwise, it does nothing and the caller should perform the repair.
+func (p *parser) deviation(format string, args ...any) {
+	if p.config.Strict {
+		panic(p.errorf(format, args...))
+	}
+}
+
 func (p *parser) dataBytesRemaining() int {
 	return len(p.data) - p.offset
 }
@@ -218,6 +227,11 @@ func (p *parser) parseModule() {
 		inst := p.parseInstrument()
 		p.module.Instruments = append(p.module.Instruments, inst)
 	}
+
+	p.startStage("")
+	if p.dataBytesRemaining() != 0 {
+		p.deviation("found %d trailing bytes", p.dataBytesRemaining())
+	}
 }
 
 func (p *parser) parseHeader() {
@@ -225,6 +239,9 @@ func (p *parser) parseHeader() {
 	if !strings.EqualFold(idText, "extended module: ") {
 		panic(p.errorf("unexpected ID text: %q", idText))
 	}
+	if idText != "Extended Module: " {
+		p.deviation("unexpected ID text case: %q", idText)
+	}
 
 	p.module.Name = strings.TrimSpace(p.readString(20, "module name"))
 
@@ -250,20 +267,42 @@ func (p *parser) parseHeader() {
 	}
 
 	p.module.RestartPosition = int(p.readWord("restart position"))
-	if p.module.RestartPosition > p.module.SongLength {
+	if p.module.RestartPosition >= p.module.SongLength {
+		p.deviation("restart position %d is out of the song length", p.module.RestartPosition)
 		p.module.RestartPosition = 0
 	}
 
 	p.module.NumChannels = int(p.readWord("number of channels"))
+	if p.module.NumChannels <= 0 || p.module.NumChannels > 32 {
+		p.deviation("invalid number of channels: %d", p.module.NumChannels)
+	}
 	p.module.NumPatterns = int(p.readWord("number of patterns"))
+	if p.module.NumPatterns > 256 {
+		p.deviation("invalid number of patterns: %d", p.module.NumPatterns)
+	}
 	p.module.NumInstruments = int(p.readWord("number of instruments"))
+	if p.module.NumInstruments > 128 {
+		p.deviation("invalid number of instruments: %d", p.module.NumInstruments)
+	}
 
 	p.module.Flags = uint16(p.readWord("flags"))
 	p.module.DefaultTempo = int(p.readWord("default tempo"))
 	p.module.DefaultBPM = int(p.readWord("default bpm"))
 
 	p.module.PatternOrder = p.read(p.module.SongLength, "pattern order table")
+	for i, patternIndex := range p.module.PatternOrder {
+		if int(patternIndex) >= p.module.NumPatterns {
+			p.deviation("pattern order[%d] refers to a non-existing pattern %d", i, patternIndex)
+		}
+	}
 
+	// The pattern order table is usually 256 bytes long,
+	// but we only need the first SongLength entries.
+	// Some trackers also pad the header, so we always
+	// rely on the declared header size to find the patterns data.
+	if p.offset > offset {
+		panic(p.errorf("consumed %d extra bytes", p.offset-offset))
+	}
 	p.offset = offset
 }
 
@@ -273,20 +312,24 @@ func (p *parser) parsePattern() Pattern {
 	if patternHeaderLength < 9 {
 		panic(p.errorf("invalid pattern header length: %d", patternHeaderLength))
 	}
-	p.skip(1, "packing type")
+	if packingType := p.readByte("packing type"); packingType != 0 {
+		p.deviation("unexpected packing type: %d", packingType)
+	}
 	numRows := int(p.readWord("number of rows"))
 	if numRows <= 0 || numRows > 256 {
 		panic(p.errorf("invalid number of rows: %d", numRows))
 	}
 
-	packedPatternDataSize := p.readWord("packed pattern data size")
-	if p.dataBytesRemaining() < int(packedPatternDataSize) {
-		panic(p.errorf("incomplete packed pattern data"))
-	}
-	offset := p.offset + int(packedPatternDataSize)
+	packedPatternDataSize := int(uint16(p.readWord("packed pattern data size")))
 
 	// Skip is usually 0, but the specs says we should respect the stated header size.
-	p.skip(int(9-patternHeaderLength), "skip pattern metadata")
+	// The header padding goes before the packed pattern data.
+	p.skip(int(patternHeaderLength-9), "pattern header padding")
+
+	if p.dataBytesRemaining() < packedPatternDataSize {
+		panic(p.errorf("incomplete packed pattern data"))
+	}
+	offset := p.offset + packedPatternDataSize
 
 	if packedPatternDataSize == 0 {
 		if p.module.EmptyPattern.Rows == nil {
@@ -385,11 +428,19 @@ func (p *parser) parseInstrument() Instrument {
 		return inst
 	}
 
-	sampleHeaderSize := p.readDword("instrument sample header size") - 4
-	if p.dataBytesRemaining() < int(sampleHeaderSize) {
-		panic(p.errorf("incomplete instrument sample header data"))
+	sampleHeaderSize := int(p.readDword("instrument sample header size"))
+	if sampleHeaderSize < standardSampleHeaderSize {
+		p.deviation("invalid sample header size: %d", sampleHeaderSize)
+		// Some trackers write 0 here; the sample headers are
+		// still stored using the standard layout.
+		sampleHeaderSize = standardSampleHeaderSize
 	}
 	inst.KeymapAssignments = p.read(96, "instrument samples keymap assignments")
+	for i, sampleIndex := range inst.KeymapAssignments {
+		if int(sampleIndex) >= int(numSamples) {
+			p.deviation("keymap[%d] refers to a non-existing sample %d", i, sampleIndex)
+		}
+	}
 
 	inst.EnvelopeVolume = p.scratchEnvelopePoints[:12]
 	for i := range inst.EnvelopeVolume {
@@ -406,6 +457,7 @@ func (p *parser) parseInstrument() Instrument {
 
 	numVolumePoints := p.readByte("number of volume points")
 	if numVolumePoints > 12 {
+		p.deviation("too many volume envelope points: %d", numVolumePoints)
 		numVolumePoints = 12
 	}
 	if numVolumePoints != 0 {
@@ -418,6 +470,7 @@ func (p *parser) parseInstrument() Instrument {
 
 	numPanningPoints := p.readByte("number of panning points")
 	if numPanningPoints > 12 {
+		p.deviation("too many panning envelope points: %d", numPanningPoints)
 		numPanningPoints = 12
 	}
 	if numPanningPoints != 0 {
@@ -455,7 +508,13 @@ func (p *parser) parseInstrument() Instrument {
 	for i := range inst.Samples {
 		p.subStageIndex = i
 		sample := &inst.Samples[i]
+		sampleHeaderOffset := p.offset + sampleHeaderSize
 		p.parseInstrumentSampleHeader(sample)
+		// Respect the declared header size: it can be padded.
+		p.offset = sampleHeaderOffset
+		if p.offset > len(p.data) {
+			panic(p.errorf("unexpected EOF while reading sample header"))
+		}
 	}
 
 	p.startSubStage("sampledata")
@@ -471,6 +530,9 @@ func (p *parser) parseInstrument() Instrument {
 	return inst
 }
 
+// standardSampleHeaderSize is a sample header size as defined by the XM specification.
+const standardSampleHeaderSize = 40
+
 func (p *parser) parseInstrumentSampleHeader(sample *InstrumentSample) {
 	sampleLength := p.readDword("sample length")
 	if p.dataBytesRemaining() < int(sampleLength) {
@@ -497,6 +559,15 @@ func (p *parser) parseInstrumentSampleHeader(sample *InstrumentSample) {
 	}
 
 	sample.Name = p.readOptionalString(22, "sample name")
+
+	if sample.LoopType() == SampleLoopUnknown {
+		p.deviation("invalid sample loop type")
+	}
+	if sample.LoopType() != SampleLoopNone {
+		if sample.LoopStart < 0 || sample.LoopLength < 0 || sample.LoopStart+sample.LoopLength > sample.Length {
+			p.deviation("sample loop [%d, +%d] is out of the sample bounds", sample.LoopStart, sample.LoopLength)
+		}
+	}
 }
 
 func (p *parser) noteHash(n PatternNote) uint64 {
@@ -0,0 +1,228 @@
+package xmfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+// testModule describes a minimal XM file for the parser tests:
+// a single pattern and a single instrument with one sample.
+type testModule struct {
+	idText         string
+	restart        int
+	numChannels    int
+	order          []byte
+	trailingBytes  int
+	headerPadding  int
+	patternPadding int
+
+	// sampleHeaderSize is a declared sample header size.
+	// The header is padded with zeros if it's bigger than the standard one.
+	sampleHeaderSize int
+
+	// keymapSample is a sample index used for all keymap entries.
+	keymapSample byte
+}
+
+func newTestModule() *testModule {
+	return &testModule{
+		idText:           "Extended Module: ",
+		numChannels:      2,
+		order:            []byte{0},
+		sampleHeaderSize: standardSampleHeaderSize,
+	}
+}
+
+func (m *testModule) encode() []byte {
+	var b bytes.Buffer
+	le16 := func(v int) { binary.Write(&b, binary.LittleEndian, uint16(v)) }
+	le32 := func(v int) { binary.Write(&b, binary.LittleEndian, uint32(v)) }
+	str := func(s string, size int) {
+		buf := make([]byte, size)
+		copy(buf, s)
+		b.Write(buf)
+	}
+
+	str(m.idText, 17)
+	str("test module", 20)
+	b.WriteByte(0x1a)
+	str("xmfile tests", 20)
+	le16(0x0104)
+	le32(276 + m.headerPadding)
+	le16(len(m.order))
+	le16(m.restart)
+	le16(m.numChannels)
+	le16(1) // Patterns
+	le16(1) // Instruments
+	le16(1)
+	le16(6)
+	le16(125)
+	str(string(m.order), 256+m.headerPadding)
+
+	// A 4-row pattern with a single C-4 note in the first row.
+	var patternData bytes.Buffer
+	patternData.Write([]byte{0x80 | 0x03, 49, 1})
+	for i := 1; i < 4*m.numChannels; i++ {
+		patternData.WriteByte(0x80)
+	}
+	le32(9 + m.patternPadding)
+	b.WriteByte(0)
+	le16(4)
+	le16(patternData.Len())
+	b.Write(make([]byte, m.patternPadding))
+	b.Write(patternData.Bytes())
+
+	sampleData := []byte{0, 10, 10, 0xf6, 0xf6}
+	le32(263)
+	str("instrument", 22)
+	b.WriteByte(0)
+	le16(1)
+	le32(m.sampleHeaderSize)
+	b.Write(bytes.Repeat([]byte{m.keymapSample}, 96))
+	b.Write(make([]byte, 4*24+2+6+2)) // Envelopes
+	b.Write(make([]byte, 4))          // Vibrato
+	le16(0x100)
+	b.Write(make([]byte, 22))
+
+	var sampleHeader bytes.Buffer
+	binary.Write(&sampleHeader, binary.LittleEndian, uint32(len(sampleData)))
+	binary.Write(&sampleHeader, binary.LittleEndian, uint32(0))
+	binary.Write(&sampleHeader, binary.LittleEndian, uint32(len(sampleData)))
+	sampleHeader.Write([]byte{48, 0, 1, 128, 0, 0})
+	sampleName := make([]byte, 22)
+	copy(sampleName, "sample")
+	sampleHeader.Write(sampleName)
+	b.Write(sampleHeader.Bytes())
+	if m.sampleHeaderSize > sampleHeader.Len() {
+		b.Write(make([]byte, m.sampleHeaderSize-sampleHeader.Len()))
+	}
+	b.Write(sampleData)
+
+	b.Write(make([]byte, m.trailingBytes))
+
+	return b.Bytes()
+}
+
+func TestParserStrictMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(m *testModule)
+		check  func(t *testing.T, m *Module)
+	}{
+		{
+			name:   "lowercase id text",
+			modify: func(m *testModule) { m.idText = "extended module: " },
+		},
+		{
+			name:   "restart position equals song length",
+			modify: func(m *testModule) { m.restart = 1 },
+			check: func(t *testing.T, m *Module) {
+				if m.RestartPosition != 0 {
+					t.Fatalf("restart position is %d, want 0", m.RestartPosition)
+				}
+			},
+		},
+		{
+			name:   "restart position is out of range",
+			modify: func(m *testModule) { m.restart = 10 },
+			check: func(t *testing.T, m *Module) {
+				if m.RestartPosition != 0 {
+					t.Fatalf("restart position is %d, want 0", m.RestartPosition)
+				}
+			},
+		},
+		{
+			name:   "too many channels",
+			modify: func(m *testModule) { m.numChannels = 33 },
+		},
+		{
+			name:   "non-existing pattern in the order",
+			modify: func(m *testModule) { m.order = []byte{0, 5} },
+		},
+		{
+			name:   "zero sample header size",
+			modify: func(m *testModule) { m.sampleHeaderSize = 0 },
+		},
+		{
+			name:   "keymap refers to a non-existing sample",
+			modify: func(m *testModule) { m.keymapSample = 3 },
+		},
+		{
+			name:   "trailing bytes",
+			modify: func(m *testModule) { m.trailingBytes = 7 },
+		},
+	}
+
+	lenient := NewParser(ParserConfig{})
+	strict := NewParser(ParserConfig{Strict: true})
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestModule()
+			test.modify(m)
+			data := m.encode()
+
+			parsed, err := lenient.ParseFromBytes(data)
+			if err != nil {
+				t.Fatalf("lenient mode: %v", err)
+			}
+			if test.check != nil {
+				test.check(t, parsed)
+			}
+
+			_, err = strict.ParseFromBytes(data)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("strict mode: expected a *ParseError, got %v", err)
+			}
+		})
+	}
+
+	t.Run("valid module", func(t *testing.T) {
+		data := newTestModule().encode()
+		if _, err := strict.ParseFromBytes(data); err != nil {
+			t.Fatalf("strict mode: %v", err)
+		}
+	})
+}
+
+func TestParsePaddedHeaders(t *testing.T) {
+	// The module header, the pattern header and the sample header
+	// are bigger than the standard ones: the parser should
+	// use the declared sizes to find the data that follows them.
+	data, err := os.ReadFile("testdata/padded_headers.xm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewParser(ParserConfig{NeedStrings: true, Strict: true})
+	m, err := p.ParseFromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Patterns) != 1 || len(m.Patterns[0].Rows) != 4 {
+		t.Fatalf("unexpected patterns layout: %+v", m.Patterns)
+	}
+	note := m.Notes[m.Patterns[0].Rows[0].Notes[0]]
+	if note.Note != 49 || note.Instrument != 1 {
+		t.Fatalf("unexpected first note: %+v", note)
+	}
+
+	if len(m.Instruments) != 1 || len(m.Instruments[0].Samples) != 1 {
+		t.Fatalf("unexpected instruments layout: %+v", m.Instruments)
+	}
+	sample := m.Instruments[0].Samples[0]
+	if sample.Name != "sample" {
+		t.Fatalf("sample name is %q, want %q", sample.Name, "sample")
+	}
+	if sample.Volume != 48 || sample.Panning != 128 {
+		t.Fatalf("unexpected sample header: %+v", sample)
+	}
+	wantData := []byte{0, 10, 10, 0xf6, 0xf6}
+	if !bytes.Equal(sample.Data, wantData) {
+		t.Fatalf("sample data is %v, want %v", sample.Data, wantData)
+	}
+}
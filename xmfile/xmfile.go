@@ -10,6 +10,14 @@ type ParserConfig struct {
 	// NeedStrings tells whether this parser needs to load optional strings
 	// like instrument names. String loading usually means more allocations.
 	NeedStrings bool
+
+	// Strict makes the parser reject the malformed files.
+	//
+	// By default, the parser tolerates some common defects and repairs them
+	// (for example, an out-of-range restart position is reset to 0).
+	// In strict mode, any deviation from the specification
+	// results in a *ParseError that describes the problem.
+	Strict bool
 }
 
 // Parser implements XM file decoding.